@@ -1,11 +1,29 @@
 package main
 
 import (
+	"flag"
+	"os"
+
 	"github.com/nartim88/urlshortener/internal/app/shortener"
+	"github.com/nartim88/urlshortener/internal/pkg/logger"
 	"github.com/nartim88/urlshortener/internal/pkg/routers"
 )
 
 func main() {
-	shortener.App.Init()
+	shortener.App.InitConfigs()
+
+	// shortener [flags] migrate up|down|status
+	if flag.Arg(0) == "migrate" {
+		if err := shortener.App.Migrate(flag.Arg(1), os.Stdout); err != nil {
+			logger.Log.Error().Err(err).Msg("migration failed")
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := shortener.App.InitServices(); err != nil {
+		logger.Log.Error().Err(err).Msg("initialization failed")
+		os.Exit(1)
+	}
 	shortener.App.Run(routers.MainRouter())
 }
@@ -3,16 +3,35 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/nartim88/urlshortener/internal/app/shortener"
+	"github.com/nartim88/urlshortener/internal/pkg/blocklist"
+	"github.com/nartim88/urlshortener/internal/pkg/middleware"
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+	"github.com/nartim88/urlshortener/internal/pkg/models/api"
+	"github.com/nartim88/urlshortener/internal/pkg/models/api/edit"
+	"github.com/nartim88/urlshortener/internal/pkg/models/api/link"
+	"github.com/nartim88/urlshortener/internal/pkg/models/api/stats"
+	"github.com/nartim88/urlshortener/internal/pkg/models/api/v1"
+	"github.com/nartim88/urlshortener/internal/pkg/models/api/v2"
 	"github.com/nartim88/urlshortener/internal/pkg/routers"
+	"github.com/nartim88/urlshortener/internal/pkg/service"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -35,7 +54,7 @@ func testRequest(t *testing.T, ts *httptest.Server, method, path string, body io
 }
 
 func TestMainRouter(t *testing.T) {
-	shortener.App.Init() // init variables for test
+	require.NoError(t, shortener.App.Init()) // init variables for test
 
 	ts := httptest.NewServer(routers.MainRouter())
 	defer ts.Close()
@@ -127,7 +146,7 @@ func TestAPI(t *testing.T) {
 				]`,
 			want: want{
 				contentType: "application/json",
-				statusCodes: []string{"201", "409"},
+				statusCodes: []string{"201", "207"},
 			},
 		},
 	}
@@ -201,4 +220,1181 @@ func TestGzipCompression(t *testing.T) {
 		_, err = io.ReadAll(zr)
 		require.NoError(t, err)
 	})
+
+	t.Run("skips_bodiless_and_redirects", func(t *testing.T) {
+		client := resty.New().
+			SetBaseURL(srv.URL).
+			SetHeader("Accept-Encoding", "gzip").
+			SetHeader("Content-Type", "application/json").
+			SetRedirectPolicy(resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			}))
+
+		var result v1.ResponsePayload
+		resp, err := client.R().
+			SetBody(map[string]any{"url": "https://practicum.yandex.ru/" + uuid.NewString()}).
+			SetResult(&result).
+			Post("/api/shorten")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode())
+		sID := path.Base(result.Result)
+
+		for path, sCode := range map[string]int{
+			"/" + sID:                       http.StatusTemporaryRedirect,
+			"/api/urls/" + sID + "/history": http.StatusNoContent,
+		} {
+			resp, err := client.R().SetDoNotParseResponse(true).Get(path)
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.RawBody())
+			resp.RawBody().Close()
+			require.NoError(t, err)
+			assert.Equal(t, sCode, resp.StatusCode(), path)
+			assert.Empty(t, resp.Header().Get("Content-Encoding"), path)
+			if sCode == http.StatusNoContent {
+				assert.Empty(t, body, path)
+			}
+		}
+	})
+}
+
+func TestUserURLs(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	client := resty.New().
+		SetBaseURL(srv.URL).
+		SetRedirectPolicy(resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}))
+
+	t.Run("no_urls", func(t *testing.T) {
+		resp, err := client.R().Get("/api/user/urls")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode())
+	})
+
+	t.Run("user_urls", func(t *testing.T) {
+		resp, err := client.R().SetBody("https://practicum.yandex.ru/" + uuid.NewString()).Post("/")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode())
+
+		resp, err = client.R().Get("/api/user/urls")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+	})
+
+	t.Run("delete_urls", func(t *testing.T) {
+		fURL := "https://practicum.yandex.ru/" + uuid.NewString()
+		resp, err := client.R().SetBody(fURL).Post("/")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode())
+		sID := path.Base(resp.String())
+
+		resp, err = client.R().SetBody([]string{sID}).Delete("/api/user/urls")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode())
+
+		assert.Eventually(t, func() bool {
+			resp, err := client.R().Get("/" + sID)
+			return err == nil && resp.StatusCode() == http.StatusGone
+		}, 10*time.Second, 100*time.Millisecond)
+
+		// удаленный урл сокращается заново под новым идентификатором
+		resp, err = client.R().SetBody(fURL).Post("/")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode())
+		assert.NotEqual(t, sID, path.Base(resp.String()))
+	})
+
+	t.Run("tampered_cookie", func(t *testing.T) {
+		resp, err := resty.New().R().
+			SetCookie(&http.Cookie{Name: middleware.AuthCookieName, Value: "tampered"}).
+			Get(srv.URL + "/api/user/urls")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode())
+
+		// вне эндпоинтов пользователя неверная cookie заменяется новой
+		resp, err = resty.New().R().
+			SetCookie(&http.Cookie{Name: middleware.AuthCookieName, Value: "tampered"}).
+			SetBody("https://practicum.yandex.ru/" + uuid.NewString()).
+			Post(srv.URL + "/")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode())
+		var issued bool
+		for _, c := range resp.Cookies() {
+			issued = issued || (c.Name == middleware.AuthCookieName && c.Value != "tampered")
+		}
+		assert.True(t, issued, "a fresh auth cookie must be issued")
+	})
+}
+
+func TestAliases(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	client := resty.New().
+		SetBaseURL(srv.URL).
+		SetHeader("Content-Type", "application/json").
+		SetRedirectPolicy(resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}))
+
+	alias := "sale-" + uuid.NewString()[:8]
+
+	var testCases = []struct {
+		name       string
+		alias      string
+		statusCode int
+		errCode    string
+	}{
+		{name: "created", alias: alias, statusCode: http.StatusCreated},
+		{name: "taken", alias: alias, statusCode: http.StatusConflict, errCode: "alias_taken"},
+		{name: "reserved", alias: "api", statusCode: http.StatusBadRequest, errCode: "invalid_alias"},
+		{name: "invalid_chars", alias: "spring sale!", statusCode: http.StatusBadRequest, errCode: "invalid_alias"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var errResp api.ErrorResponse
+			resp, err := client.R().
+				SetBody(map[string]string{"url": "https://practicum.yandex.ru/" + uuid.NewString(), "alias": tc.alias}).
+				SetError(&errResp).
+				Post("/api/shorten")
+			require.NoError(t, err)
+			assert.Equal(t, tc.statusCode, resp.StatusCode())
+			assert.Equal(t, tc.errCode, errResp.Error.Code)
+		})
+	}
+
+	t.Run("redirect", func(t *testing.T) {
+		resp, err := client.R().Get("/" + alias)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+	})
+}
+
+func TestExpiration(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	client := resty.New().
+		SetBaseURL(srv.URL).
+		SetHeader("Content-Type", "application/json").
+		SetRedirectPolicy(resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}))
+
+	t.Run("max_clicks", func(t *testing.T) {
+		var result v1.ResponsePayload
+		resp, err := client.R().
+			SetBody(map[string]any{"url": "https://practicum.yandex.ru/" + uuid.NewString(), "max_clicks": 1}).
+			SetResult(&result).
+			Post("/api/shorten")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode())
+
+		sID := "/" + path.Base(result.Result)
+
+		resp, err = client.R().Get(sID)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+
+		resp, err = client.R().Get(sID)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusGone, resp.StatusCode())
+	})
+
+	t.Run("expires_at_in_past", func(t *testing.T) {
+		var errResp api.ErrorResponse
+		resp, err := client.R().
+			SetBody(map[string]any{"url": "https://practicum.yandex.ru/", "expires_at": time.Now().Add(-time.Hour)}).
+			SetError(&errResp).
+			Post("/api/shorten")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+		assert.Equal(t, api.ErrCodeInvalidExpiration, errResp.Error.Code)
+	})
+}
+
+func TestURLStats(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	client := resty.New().
+		SetBaseURL(srv.URL).
+		SetHeader("Content-Type", "application/json").
+		SetRedirectPolicy(resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}))
+
+	var result v1.ResponsePayload
+	resp, err := client.R().
+		SetBody(map[string]any{"url": "https://practicum.yandex.ru/" + uuid.NewString()}).
+		SetResult(&result).
+		Post("/api/shorten")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode())
+
+	sID := path.Base(result.Result)
+
+	for _, referrer := range []string{"https://t.me/channel", "https://t.me/other", ""} {
+		resp, err = client.R().SetHeader("Referer", referrer).Get("/" + sID)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+	}
+
+	var urlStats stats.Response
+	assert.Eventually(t, func() bool {
+		resp, err = client.R().SetResult(&urlStats).Get("/api/urls/" + sID + "/stats")
+		return err == nil && resp.StatusCode() == http.StatusOK && urlStats.TotalClicks == 3
+	}, 10*time.Second, 100*time.Millisecond)
+
+	assert.Equal(t, int64(1), urlStats.UniqueVisitors)
+	assert.Equal(t, []stats.ReferrerClicks{{Referrer: "t.me", Clicks: 2}, {Referrer: "direct", Clicks: 1}}, urlStats.ByReferrer)
+	require.Len(t, urlStats.ByDay, 1)
+
+	t.Run("not_found", func(t *testing.T) {
+		resp, err := client.R().Get("/api/urls/" + uuid.NewString() + "/stats")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+	})
+
+	t.Run("owner_only", func(t *testing.T) {
+		resp, err := resty.New().SetBaseURL(srv.URL).R().Get("/api/urls/" + sID + "/stats")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode())
+	})
+}
+
+func TestBatchStatuses(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	client := resty.New().SetBaseURL(srv.URL).SetHeader("Content-Type", "application/json")
+
+	newURL := "https://practicum.yandex.ru/" + uuid.NewString()
+	body := []map[string]string{
+		{"correlation_id": "1", "original_url": newURL},
+		{"correlation_id": "2", "original_url": newURL},
+		{"correlation_id": "3", "original_url": "https://ya.ru", "alias": "api"},
+		{"correlation_id": "4", "original_url": ""},
+	}
+
+	var result []v2.ResponsePayload
+	resp, err := client.R().SetBody(body).SetResult(&result).Post("/api/shorten/batch")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode())
+	require.Len(t, result, 4)
+
+	assert.Equal(t, v2.StatusCreated, result[0].Status)
+	assert.Equal(t, v2.StatusExists, result[1].Status)
+	assert.Equal(t, result[0].ShortURL, result[1].ShortURL)
+	assert.Equal(t, v2.StatusInvalid, result[2].Status)
+	assert.NotEmpty(t, result[2].Error)
+	assert.Equal(t, v2.StatusInvalid, result[3].Status)
+
+	t.Run("malformed_json", func(t *testing.T) {
+		resp, err := client.R().SetBody(`[{"original_url":`).Post("/api/shorten/batch")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	})
+}
+
+func TestStreamShorten(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	client := resty.New().SetBaseURL(srv.URL)
+
+	newURL := "https://practicum.yandex.ru/" + uuid.NewString()
+	body := `{"correlation_id":"1","original_url":"` + newURL + `"}
+
+{"correlation_id":"2","original_url":""}
+{"correlation_id":"3","original_url":
+`
+
+	resp, err := client.R().
+		SetHeader("Content-Type", "application/x-ndjson").
+		SetBody(body).
+		Post("/api/shorten/stream")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "application/x-ndjson", resp.Header().Get("Content-Type"))
+
+	results := make(map[string]v2.ResponsePayload)
+	dec := json.NewDecoder(bytes.NewReader(resp.Body()))
+	for dec.More() {
+		var res v2.ResponsePayload
+		require.NoError(t, dec.Decode(&res))
+		results[string(res.CorrelationID)] = res
+	}
+
+	require.Len(t, results, 3)
+	assert.Equal(t, v2.StatusCreated, results["1"].Status)
+	assert.NotEmpty(t, results["1"].ShortURL)
+	assert.Equal(t, v2.StatusInvalid, results["2"].Status)
+	assert.Equal(t, v2.StatusInvalid, results[""].Status, "malformed line has no correlation id")
+
+	t.Run("unsupported_media_type", func(t *testing.T) {
+		resp, err := client.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(`[]`).
+			Post("/api/shorten/stream")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode())
+	})
+}
+
+func TestURLNormalization(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	client := resty.New().SetBaseURL(srv.URL)
+
+	id := uuid.NewString()
+	resp, err := client.R().SetBody("https://practicum.yandex.ru/" + id).Post("/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode())
+	shortURL := string(resp.Body())
+
+	resp, err = client.R().SetBody(" HTTPS://Practicum.Yandex.RU:443/" + id + "\n").Post("/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode())
+	assert.Equal(t, shortURL, string(resp.Body()))
+
+	for _, raw := range []string{"javascript:alert(1)", "/relative/path", "ftp://ya.ru"} {
+		var errResp api.ErrorResponse
+		resp, err = client.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(map[string]string{"url": raw}).
+			SetError(&errResp).
+			Post("/api/shorten")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode(), raw)
+		assert.Equal(t, api.ErrCodeInvalidURL, errResp.Error.Code, raw)
+	}
+}
+
+func TestSelfLinks(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	client := resty.New().SetBaseURL(srv.URL)
+
+	resp, err := client.R().SetBody("https://practicum.yandex.ru/" + uuid.NewString()).Post("/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode())
+	shortURL := string(resp.Body())
+
+	var errResp api.ErrorResponse
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(map[string]string{"url": shortURL}).
+		SetError(&errResp).
+		Post("/api/shorten")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode())
+	assert.Equal(t, api.ErrCodeSelfLink, errResp.Error.Code)
+
+	var batchResp []v2.ResponsePayload
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody([]v2.RequestData{
+			{CorrelationID: "self", FullURL: models.FullURL(shortURL)},
+			{CorrelationID: "foreign", FullURL: models.FullURL("https://go.dev/" + uuid.NewString())},
+		}).
+		SetResult(&batchResp).
+		Post("/api/shorten/batch")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode())
+	require.Len(t, batchResp, 2)
+	assert.Equal(t, v2.StatusInvalid, batchResp[0].Status)
+	assert.Equal(t, v2.StatusCreated, batchResp[1].Status)
+}
+
+func TestBlocklist(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	client := resty.New().
+		SetBaseURL(srv.URL).
+		SetRedirectPolicy(resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}))
+
+	domain := uuid.NewString() + ".com"
+	resp, err := client.R().SetBody("https://" + domain + "/login").Post("/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode())
+	shortURL := string(resp.Body())
+
+	blPath := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(blPath, []byte(domain+"\n"), 0666))
+	bl, err := blocklist.New(blPath, time.Second)
+	require.NoError(t, err)
+
+	prev := shortener.App.Blocklist
+	shortener.App.Blocklist = bl
+	defer func() { shortener.App.Blocklist = prev }()
+
+	var errResp api.ErrorResponse
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(map[string]string{"url": "https://www." + domain}).
+		SetError(&errResp).
+		Post("/api/shorten")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode())
+	assert.Equal(t, api.ErrCodeBlockedURL, errResp.Error.Code)
+	assert.Equal(t, &api.BlockReason{Kind: blocklist.KindDomain, Rule: domain}, errResp.Error.Reason)
+
+	var batchResp []v2.ResponsePayload
+	_, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody([]v2.RequestData{{CorrelationID: "1", FullURL: models.FullURL("https://" + domain)}}).
+		SetResult(&batchResp).
+		Post("/api/shorten/batch")
+	require.NoError(t, err)
+	require.Len(t, batchResp, 1)
+	assert.Equal(t, v2.StatusBlocked, batchResp[0].Status)
+
+	resp, err = client.R().SetError(&errResp).Get(path.Base(shortURL))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, resp.StatusCode())
+	assert.Empty(t, resp.Header().Get("Location"))
+}
+
+func TestRedirectCodes(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	client := resty.New().
+		SetBaseURL(srv.URL).
+		SetHeader("Content-Type", "application/json").
+		SetRedirectPolicy(resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}))
+
+	fURL := "https://practicum.yandex.ru/" + uuid.NewString()
+	var testCases = []struct {
+		name         string
+		body         map[string]any
+		wantCode     int
+		cacheControl string
+	}{
+		{name: "default", body: map[string]any{"url": fURL}, wantCode: http.StatusTemporaryRedirect, cacheControl: "no-store"},
+		{name: "permanent", body: map[string]any{"url": fURL, "redirect_code": 308}, wantCode: http.StatusPermanentRedirect, cacheControl: "public, max-age=86400"},
+		{name: "found", body: map[string]any{"url": fURL, "redirect_code": 302}, wantCode: http.StatusFound, cacheControl: "no-store"},
+		{name: "permanent_with_max_clicks", body: map[string]any{"url": fURL, "redirect_code": 301, "max_clicks": 5}, wantCode: http.StatusMovedPermanently, cacheControl: "no-store"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var result v1.ResponsePayload
+			resp, err := client.R().SetBody(tc.body).SetResult(&result).Post("/api/shorten")
+			require.NoError(t, err)
+			require.Equal(t, http.StatusCreated, resp.StatusCode(), "links with own redirect code are not deduplicated")
+
+			resp, err = client.R().Get("/" + path.Base(result.Result))
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantCode, resp.StatusCode())
+			assert.Equal(t, fURL, resp.Header().Get("Location"))
+			assert.Equal(t, tc.cacheControl, resp.Header().Get("Cache-Control"))
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		var errResp api.ErrorResponse
+		resp, err := client.R().
+			SetBody(map[string]any{"url": fURL, "redirect_code": 200}).
+			SetError(&errResp).
+			Post("/api/shorten")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+		assert.Equal(t, api.ErrCodeInvalidRedirect, errResp.Error.Code)
+	})
+}
+
+func TestPassthrough(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	client := resty.New().
+		SetBaseURL(srv.URL).
+		SetHeader("Content-Type", "application/json").
+		SetRedirectPolicy(resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}))
+
+	section := "https://practicum.yandex.ru/" + uuid.NewString()
+	shorten := func(t *testing.T, body map[string]any) string {
+		var result v1.ResponsePayload
+		resp, err := client.R().SetBody(body).SetResult(&result).Post("/api/shorten")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode())
+		return "/" + path.Base(result.Result)
+	}
+
+	t.Run("passthrough", func(t *testing.T) {
+		sID := shorten(t, map[string]any{"url": section + "/docs?lang=ru", "passthrough": true})
+
+		resp, err := client.R().Get(sID + "/guide/page?utm_source=mail&lang=en")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+		assert.Equal(t, section+"/docs/guide/page?lang=ru&utm_source=mail", resp.Header().Get("Location"))
+
+		resp, err = client.R().Get(sID)
+		require.NoError(t, err)
+		assert.Equal(t, section+"/docs?lang=ru", resp.Header().Get("Location"))
+
+		resp, err = client.R().Get(sID + "/%2e%2e/admin")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		sID := shorten(t, map[string]any{"url": section + "/docs", "redirect_code": 302})
+
+		resp, err := client.R().Get(sID + "?utm_source=mail")
+		require.NoError(t, err)
+		assert.Equal(t, section+"/docs", resp.Header().Get("Location"))
+
+		resp, err = client.R().Get(sID + "/guide")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+	})
+}
+
+func TestUTM(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	client := resty.New().
+		SetBaseURL(srv.URL).
+		SetHeader("Content-Type", "application/json").
+		SetRedirectPolicy(resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}))
+
+	fURL := "https://practicum.yandex.ru/" + uuid.NewString()
+	redirect := func(t *testing.T, body map[string]any) string {
+		var result v1.ResponsePayload
+		resp, err := client.R().SetBody(body).SetResult(&result).Post("/api/shorten")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode())
+
+		resp, err = client.R().Get("/" + path.Base(result.Result))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+		return resp.Header().Get("Location")
+	}
+
+	t.Run("link_utm", func(t *testing.T) {
+		location := redirect(t, map[string]any{
+			"url": fURL + "?utm_source=site",
+			"utm": models.UTM{Source: "mail", Medium: "email"},
+		})
+		assert.Equal(t, fURL+"?utm_source=site&utm_medium=email", location)
+	})
+
+	t.Run("user_defaults", func(t *testing.T) {
+		resp, err := client.R().Get("/api/user/utm")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode())
+
+		resp, err = client.R().SetBody(models.UTM{Source: "mail", Campaign: "spring"}).Put("/api/user/utm")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode())
+
+		var defaults models.UTM
+		resp, err = client.R().SetResult(&defaults).Get("/api/user/utm")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Equal(t, models.UTM{Source: "mail", Campaign: "spring"}, defaults)
+
+		location := redirect(t, map[string]any{"url": fURL, "utm": models.UTM{Source: "ads"}})
+		assert.Equal(t, fURL+"?utm_campaign=spring&utm_source=ads", location)
+
+		resp, err = client.R().Delete("/api/user/utm")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode())
+
+		resp, err = client.R().SetBody(map[string]any{"url": fURL}).Post("/api/shorten")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	})
+}
+
+func TestPasswordLinks(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	client := resty.New().
+		SetBaseURL(srv.URL).
+		SetHeader("Content-Type", "application/json").
+		SetRedirectPolicy(resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}))
+
+	fURL := "https://practicum.yandex.ru/" + uuid.NewString()
+	shorten := func(t *testing.T, body map[string]any) string {
+		var result v1.ResponsePayload
+		resp, err := client.R().SetBody(body).SetResult(&result).Post("/api/shorten")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode())
+		return "/" + path.Base(result.Result)
+	}
+
+	t.Run("header", func(t *testing.T) {
+		sURL := shorten(t, map[string]any{"url": fURL, "password": "secret"})
+
+		resp, err := client.R().SetHeader("X-Link-Password", "wrong").Get(sURL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode())
+
+		resp, err = client.R().SetHeader("X-Link-Password", "secret").Get(sURL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+		assert.Equal(t, fURL, resp.Header().Get("Location"))
+		assert.Equal(t, "no-store", resp.Header().Get("Cache-Control"))
+	})
+
+	t.Run("form", func(t *testing.T) {
+		sURL := shorten(t, map[string]any{"url": fURL, "password": "secret"})
+
+		resp, err := client.R().Get(sURL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode())
+		assert.Contains(t, resp.Header().Get("Content-Type"), "text/html")
+		assert.Contains(t, resp.String(), `name="password"`)
+
+		resp, err = client.R().SetFormData(map[string]string{"password": "secret"}).Post(sURL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusSeeOther, resp.StatusCode())
+		assert.Equal(t, fURL, resp.Header().Get("Location"))
+	})
+
+	t.Run("client_hash_ignored", func(t *testing.T) {
+		sURL := shorten(t, map[string]any{"url": fURL, "password_hash": "$2a$10$forged"})
+
+		resp, err := client.R().Get(sURL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+	})
+
+	t.Run("rate_limit", func(t *testing.T) {
+		sURL := shorten(t, map[string]any{"url": fURL, "password": "secret"})
+
+		for i := 0; i < shortener.App.Configs.PasswordMaxAttempts; i++ {
+			resp, err := client.R().SetHeader("X-Link-Password", "wrong").Get(sURL)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusUnauthorized, resp.StatusCode())
+		}
+
+		resp, err := client.R().SetHeader("X-Link-Password", "secret").Get(sURL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode())
+		assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+	})
+
+	t.Run("concurrent_rate_limit", func(t *testing.T) {
+		sURL := shorten(t, map[string]any{"url": fURL, "password": "secret"})
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		codes := make(map[int]int)
+		for i := 0; i < 4*shortener.App.Configs.PasswordMaxAttempts; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := client.R().SetHeader("X-Link-Password", "wrong").Get(sURL)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				codes[resp.StatusCode()]++
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, shortener.App.Configs.PasswordMaxAttempts, codes[http.StatusUnauthorized],
+			"concurrent guesses must not get past the limit")
+	})
+
+	t.Run("too_long", func(t *testing.T) {
+		resp, err := client.R().
+			SetBody(map[string]any{"url": fURL, "password": strings.Repeat("a", 73)}).
+			Post("/api/shorten")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	})
+}
+
+func TestOneTimeLinks(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	client := resty.New().
+		SetBaseURL(srv.URL).
+		SetHeader("Content-Type", "application/json").
+		SetRedirectPolicy(resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}))
+
+	fURL := "https://practicum.yandex.ru/" + uuid.NewString()
+
+	t.Run("concurrent_clicks", func(t *testing.T) {
+		var result v1.ResponsePayload
+		resp, err := client.R().
+			SetBody(map[string]any{"url": fURL, "one_time": true}).
+			SetResult(&result).
+			Post("/api/shorten")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode())
+		sURL := "/" + path.Base(result.Result)
+
+		const clicks = 10
+		codes := make(chan int, clicks)
+		var wg sync.WaitGroup
+		for i := 0; i < clicks; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := client.R().Get(sURL)
+				if assert.NoError(t, err) {
+					codes <- resp.StatusCode()
+				}
+			}()
+		}
+		wg.Wait()
+		close(codes)
+
+		counts := make(map[int]int)
+		for code := range codes {
+			counts[code]++
+		}
+		assert.Equal(t, map[int]int{http.StatusTemporaryRedirect: 1, http.StatusGone: clicks - 1}, counts)
+	})
+
+	t.Run("wrong_password_does_not_burn", func(t *testing.T) {
+		var result v1.ResponsePayload
+		resp, err := client.R().
+			SetBody(map[string]any{"url": fURL, "one_time": true, "password": "secret"}).
+			SetResult(&result).
+			Post("/api/shorten")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode())
+		sURL := "/" + path.Base(result.Result)
+
+		resp, err = client.R().SetHeader("X-Link-Password", "wrong").Get(sURL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode())
+
+		resp, err = client.R().SetHeader("X-Link-Password", "secret").Get(sURL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+
+		resp, err = client.R().SetHeader("X-Link-Password", "secret").Get(sURL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusGone, resp.StatusCode())
+	})
+
+	t.Run("text_api", func(t *testing.T) {
+		resp, err := client.R().SetBody(fURL).Post("/?one_time=true")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode())
+		sURL := "/" + path.Base(resp.String())
+
+		resp, err = client.R().Get(sURL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+
+		resp, err = client.R().Get(sURL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusGone, resp.StatusCode())
+	})
+
+	t.Run("conflicts_with_max_clicks", func(t *testing.T) {
+		resp, err := client.R().
+			SetBody(map[string]any{"url": fURL, "one_time": true, "max_clicks": 3}).
+			Post("/api/shorten")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	})
+
+	t.Run("consumed_after_purge", func(t *testing.T) {
+		alias := "invite-" + uuid.NewString()[:8]
+		body := map[string]any{"url": fURL, "one_time": true, "alias": alias}
+		resp, err := client.R().SetBody(body).Post("/api/shorten")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode())
+
+		resp, err = client.R().Get("/" + alias)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+
+		_, err = shortener.App.Store.PurgeExpired(context.Background(), time.Now())
+		require.NoError(t, err)
+
+		resp, err = client.R().Get("/" + alias)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusGone, resp.StatusCode(), "consumed link stays gone after purge")
+
+		resp, err = resty.New().SetBaseURL(srv.URL).R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(map[string]any{"url": "https://evil.example/" + uuid.NewString(), "alias": alias}).
+			Post("/api/shorten")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode(), "consumed alias must not be handed out again")
+	})
+}
+
+func TestSignedLinks(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	client := resty.New().
+		SetBaseURL(srv.URL).
+		SetRedirectPolicy(resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}))
+
+	// ссылка, выданная до включения подписи
+	fURL := "https://practicum.yandex.ru/" + uuid.NewString()
+	resp, err := client.R().SetBody(fURL).Post("/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode())
+	unsigned := path.Base(resp.String())
+
+	useSigner := func(t *testing.T, mode string) {
+		signer, err := service.NewLinkSigner(mode, "k2:new-secret,k1:old-secret")
+		require.NoError(t, err)
+		prev := shortener.App.Signer
+		shortener.App.Signer = signer
+		t.Cleanup(func() { shortener.App.Signer = prev })
+	}
+
+	t.Run("required", func(t *testing.T) {
+		useSigner(t, service.SigningRequired)
+
+		fURL := "https://practicum.yandex.ru/" + uuid.NewString()
+		resp, err := client.R().SetBody(fURL).Post("/")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode())
+		signed := path.Base(resp.String())
+		require.True(t, strings.HasPrefix(signed, "k2."), signed)
+
+		resp, err = client.R().Get("/" + signed)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+		assert.Equal(t, fURL, resp.Header().Get("Location"))
+
+		resp, err = client.R().Get("/api/urls/" + signed + "/stats")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+		sID := strings.Split(signed, ".")[1]
+		for _, bad := range []string{sID, unsigned, signed + "x", "k1." + sID + ".AAAAAAAAAAA"} {
+			resp, err = client.R().Get("/" + bad)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode(), bad)
+		}
+	})
+
+	t.Run("optional", func(t *testing.T) {
+		useSigner(t, service.SigningOptional)
+
+		resp, err := client.R().Get("/" + unsigned)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+		assert.Equal(t, fURL, resp.Header().Get("Location"))
+	})
+}
+
+func TestEditURL(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	newClient := func() *resty.Client {
+		return resty.New().
+			SetBaseURL(srv.URL).
+			SetHeader("Content-Type", "application/json").
+			SetRedirectPolicy(resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			}))
+	}
+	client := newClient()
+
+	oldURL := "https://practicum.yandex.ru/" + uuid.NewString()
+	newURL := "https://practicum.yandex.ru/" + uuid.NewString()
+
+	var result v1.ResponsePayload
+	resp, err := client.R().
+		SetBody(map[string]any{"url": oldURL, "redirect_code": http.StatusFound}).
+		SetResult(&result).
+		Post("/api/shorten")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode())
+	sID := path.Base(result.Result)
+
+	resp, err = client.R().Get("/api/urls/" + sID + "/history")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode())
+
+	t.Run("edit", func(t *testing.T) {
+		var change edit.Change
+		resp, err := client.R().
+			SetBody(map[string]any{"url": newURL, "utm": models.UTM{Source: "poster"}, "password_hash": "forged"}).
+			SetResult(&change).
+			Patch("/api/urls/" + sID)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Equal(t, models.FullURL(oldURL), change.Old.FullURL)
+		assert.Equal(t, edit.Target{
+			FullURL:      models.FullURL(newURL),
+			RedirectCode: http.StatusFound,
+			UTM:          &models.UTM{Source: "poster"},
+		}, change.New, "fields missing in the request keep their values")
+
+		resp, err = client.R().Get("/" + sID)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusFound, resp.StatusCode())
+		assert.Equal(t, newURL+"?utm_source=poster", resp.Header().Get("Location"))
+	})
+
+	t.Run("reset_option", func(t *testing.T) {
+		resp, err := client.R().SetBody(map[string]any{"utm": nil, "password": "secret"}).Patch("/api/urls/" + sID)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+
+		resp, err = client.R().SetHeader("X-Link-Password", "secret").Get("/" + sID)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusFound, resp.StatusCode())
+		assert.Equal(t, newURL, resp.Header().Get("Location"))
+	})
+
+	t.Run("rejected_edits", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			body  map[string]any
+			sCode int
+		}{
+			{"empty_url", map[string]any{"url": ""}, http.StatusUnprocessableEntity},
+			{"null_url", map[string]any{"url": nil}, http.StatusUnprocessableEntity},
+			{"forged_hash_only", map[string]any{"password_hash": "forged"}, http.StatusBadRequest},
+			{"empty_body", map[string]any{}, http.StatusBadRequest},
+			{"same_values", map[string]any{"url": newURL, "redirect_code": http.StatusFound}, http.StatusBadRequest},
+			{"invalid_redirect_code", map[string]any{"redirect_code": 999}, http.StatusBadRequest},
+			{"past_expiration", map[string]any{"expires_at": time.Now().Add(-time.Hour)}, http.StatusBadRequest},
+			{"negative_max_clicks", map[string]any{"max_clicks": -1}, http.StatusBadRequest},
+			{"one_time_conflict", map[string]any{"one_time": true, "max_clicks": 5}, http.StatusBadRequest},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				resp, err := client.R().SetBody(tt.body).Patch("/api/urls/" + sID)
+				require.NoError(t, err)
+				assert.Equal(t, tt.sCode, resp.StatusCode(), resp.String())
+			})
+		}
+	})
+
+	t.Run("history", func(t *testing.T) {
+		var history []edit.Change
+		resp, err := client.R().SetResult(&history).Get("/api/urls/" + sID + "/history")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+		require.Len(t, history, 2)
+		assert.Equal(t, models.FullURL(oldURL), history[0].Old.FullURL)
+		assert.Equal(t, history[0].New, edit.Target{
+			FullURL:      models.FullURL(newURL),
+			RedirectCode: http.StatusFound,
+			UTM:          &models.UTM{Source: "poster"},
+		})
+		assert.True(t, history[1].New.PasswordProtected)
+		assert.NotContains(t, resp.String(), "password_hash")
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		stranger := newClient()
+		resp, err := stranger.R().SetBody(map[string]any{"url": oldURL}).Patch("/api/urls/" + sID)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode())
+
+		resp, err = stranger.R().Get("/api/urls/" + sID + "/history")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode())
+
+		resp, err = resty.New().R().
+			SetCookie(&http.Cookie{Name: middleware.AuthCookieName, Value: "tampered"}).
+			SetHeader("Content-Type", "application/json").
+			SetBody(map[string]any{"url": oldURL}).
+			Patch(srv.URL + "/api/urls/" + sID)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode(), "invalid cookie is not a stranger")
+
+		resp, err = client.R().SetBody(map[string]any{"url": oldURL}).Patch("/api/urls/" + uuid.NewString())
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+
+		resp, err = client.R().SetBody(map[string]any{"url": "not a url"}).Patch("/api/urls/" + sID)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode())
+
+		resp, err = client.R().SetBody(map[string]any{"url": result.Result}).Patch("/api/urls/" + sID)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode(), "url must not point to the service")
+	})
+}
+
+func TestURLInfo(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	newClient := func() *resty.Client {
+		return resty.New().
+			SetBaseURL(srv.URL).
+			SetHeader("Content-Type", "application/json").
+			SetRedirectPolicy(resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			}))
+	}
+	client, stranger := newClient(), newClient()
+
+	shorten := func(body map[string]any) string {
+		var result v1.ResponsePayload
+		resp, err := client.R().SetBody(body).SetResult(&result).Post("/api/shorten")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode())
+		return path.Base(result.Result)
+	}
+
+	oneTimeURL := "https://practicum.yandex.ru/" + uuid.NewString()
+	oneTimeID := shorten(map[string]any{"url": oneTimeURL, "one_time": true})
+	protectedURL := "https://practicum.yandex.ru/" + uuid.NewString()
+	protectedID := shorten(map[string]any{"url": protectedURL, "password": "secret"})
+
+	t.Run("info", func(t *testing.T) {
+		var info link.Response
+		resp, err := client.R().SetResult(&info).Get("/api/urls/" + oneTimeID)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Equal(t, models.FullURL(oneTimeURL), info.FullURL)
+		assert.Equal(t, link.StatusActive, info.Status)
+		assert.Equal(t, int64(1), info.MaxClicks)
+		assert.NotEmpty(t, info.UserID)
+		require.NotNil(t, info.CreatedAt)
+		assert.WithinDuration(t, time.Now(), *info.CreatedAt, time.Minute)
+	})
+
+	t.Run("info_is_owner_only", func(t *testing.T) {
+		for _, sID := range []string{oneTimeID, protectedID} {
+			resp, err := stranger.R().Get("/api/urls/" + sID)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode(), sID)
+			assert.NotContains(t, resp.String(), "practicum.yandex.ru", sID)
+		}
+	})
+
+	t.Run("protected_info", func(t *testing.T) {
+		var info link.Response
+		resp, err := client.R().SetResult(&info).Get("/api/urls/" + protectedID)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.True(t, info.PasswordProtected)
+		assert.Equal(t, models.FullURL(protectedURL), info.FullURL)
+		assert.NotContains(t, resp.String(), "password_hash")
+	})
+
+	t.Run("preview", func(t *testing.T) {
+		resp, err := stranger.R().Get("/" + oneTimeID + "+")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Contains(t, resp.Header().Get("Content-Type"), "text/html")
+		assert.Contains(t, resp.String(), oneTimeURL)
+
+		resp, err = stranger.R().Get("/" + protectedID + "+")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.NotContains(t, resp.String(), protectedURL)
+		assert.Contains(t, resp.String(), "password protected")
+	})
+
+	t.Run("preview_does_not_consume_link", func(t *testing.T) {
+		resp, err := stranger.R().Get("/" + oneTimeID)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+
+		var info link.Response
+		resp, err = client.R().SetResult(&info).Get("/api/urls/" + oneTimeID)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Equal(t, link.StatusExpired, info.Status)
+
+		resp, err = stranger.R().Get("/" + oneTimeID + "+")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusGone, resp.StatusCode())
+	})
+
+	t.Run("tampered_cookie", func(t *testing.T) {
+		for _, p := range []string{"", "/stats", "/history"} {
+			resp, err := resty.New().R().
+				SetCookie(&http.Cookie{Name: middleware.AuthCookieName, Value: "tampered"}).
+				Get(srv.URL + "/api/urls/" + oneTimeID + p)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode(), p)
+		}
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		resp, err := stranger.R().Get("/api/urls/" + uuid.NewString())
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+
+		resp, err = stranger.R().Get("/unknown+")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+	})
+}
+
+func TestLookupURL(t *testing.T) {
+	srv := httptest.NewServer(routers.MainRouter())
+	defer srv.Close()
+
+	client := resty.New().SetBaseURL(srv.URL)
+
+	fURL := "https://practicum.yandex.ru/" + uuid.NewString()
+	var result v1.ResponsePayload
+	resp, err := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(map[string]any{"url": fURL}).
+		SetResult(&result).
+		Post("/api/shorten")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode())
+
+	t.Run("found", func(t *testing.T) {
+		var found link.LookupResponse
+		resp, err := client.R().SetQueryParam("url", fURL).SetResult(&found).Get("/api/lookup")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Equal(t, result.Result, found.ShortURL)
+		assert.Equal(t, path.Base(result.Result), found.ID)
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		resp, err := client.R().SetQueryParam("url", "https://practicum.yandex.ru/"+uuid.NewString()).Get("/api/lookup")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		resp, err := client.R().Get("/api/lookup")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+		resp, err = client.R().SetQueryParam("url", "not a url").Get("/api/lookup")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode())
+	})
 }
@@ -0,0 +1,63 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nartim88/urlshortener/internal/pkg/migrations"
+)
+
+// Migrate выполняет команду миграции схемы бд: up, down или status.
+// Состояние миграций для status выводится в out
+func (a *Application) Migrate(cmd string, out io.Writer) error {
+	if a.Configs.DatabaseDSN == "" {
+		return errors.New("database DSN is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	pool, err := a.newDBPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	m, err := migrations.New(pool)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "up":
+		return m.Up(ctx)
+	case "down":
+		return m.Down(ctx)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		return printStatuses(out, statuses)
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", cmd)
+	}
+}
+
+// printStatuses выводит состояние миграций в виде таблицы
+func printStatuses(out io.Writer, statuses []migrations.Status) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, st := range statuses {
+		appliedAt := "pending"
+		if st.AppliedAt != nil {
+			appliedAt = st.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", st.Version, st.Name, appliedAt)
+	}
+	return w.Flush()
+}
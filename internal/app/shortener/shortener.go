@@ -2,29 +2,56 @@ package shortener
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nartim88/urlshortener/internal/pkg/blocklist"
 	"github.com/nartim88/urlshortener/internal/pkg/config"
+	"github.com/nartim88/urlshortener/internal/pkg/deleter"
+	"github.com/nartim88/urlshortener/internal/pkg/janitor"
 	"github.com/nartim88/urlshortener/internal/pkg/logger"
+	"github.com/nartim88/urlshortener/internal/pkg/recorder"
+	"github.com/nartim88/urlshortener/internal/pkg/service"
 	"github.com/nartim88/urlshortener/internal/pkg/storage"
 )
 
 type Application struct {
-	Store   storage.Storage
-	Configs config.Config
+	Store    storage.Storage
+	Configs  config.Config
+	Deleter  *deleter.Deleter
+	Janitor  *janitor.Janitor
+	Recorder *recorder.Recorder
+	// SelfLinks распознает урлы на короткие ссылки самого сервиса
+	SelfLinks *service.SelfLinkGuard
+	Blocklist *blocklist.Blocklist
+	// PasswordAttempts ограничивает подбор паролей к защищенным урлам
+	PasswordAttempts *service.AttemptLimiter
+	// Signer подписывает короткие ссылки и проверяет подпись
+	Signer *service.LinkSigner
 }
 
 var App Application
 
+// secretKeyLen длина ключа подписи, генерируемого при отсутствии SECRET_KEY
+const secretKeyLen = 32
+
 // Init первичная инициализация приложения
-func (a *Application) Init() {
+func (a *Application) Init() error {
+	a.InitConfigs()
+	return a.InitServices()
+}
+
+// InitConfigs инициализация конфигов и логгера
+func (a *Application) InitConfigs() {
 
 	// инициализация конфигов
 	a.Configs = *config.NewConfig()
@@ -40,14 +67,97 @@ func (a *Application) Init() {
 	logger.Log.Info().Str("BASE_URL", a.Configs.BaseURL).Send()
 	logger.Log.Info().Str("LOG_LEVEL", a.Configs.LogLevel).Send()
 	logger.Log.Info().Str("FILE_STORAGE_PATH", a.Configs.FileStoragePath).Send()
+	logger.Log.Info().Str("FILE_SYNC_POLICY", a.Configs.FileSyncPolicy).Send()
+	logger.Log.Info().Str("FILE_SYNC_INTERVAL", a.Configs.FileSyncInterval.String()).Send()
 	logger.Log.Info().Str("DATABASE_DSN", a.Configs.DatabaseDSN).Send()
+	logger.Log.Info().Str("ID_STRATEGY", a.Configs.IDStrategy).Send()
+	logger.Log.Info().Int("ID_LENGTH", a.Configs.IDLength).Send()
+	logger.Log.Info().Int("DB_MAX_CONNS", a.Configs.DBMaxConns).Send()
+	logger.Log.Info().Int("DB_MIN_CONNS", a.Configs.DBMinConns).Send()
+	logger.Log.Info().Str("DB_MAX_CONN_LIFETIME", a.Configs.DBMaxConnLifetime.String()).Send()
+	logger.Log.Info().Str("DB_HEALTH_CHECK_PERIOD", a.Configs.DBHealthCheckPeriod.String()).Send()
+	logger.Log.Info().Str("PURGE_INTERVAL", a.Configs.PurgeInterval.String()).Send()
+	logger.Log.Info().Str("ALIAS_HOSTS", a.Configs.AliasHosts).Send()
+	logger.Log.Info().Str("SELF_LINK_POLICY", a.Configs.SelfLinkPolicy).Send()
+	logger.Log.Info().Int("MAX_REDIRECT_DEPTH", a.Configs.MaxRedirectDepth).Send()
+	logger.Log.Info().Str("BLOCKLIST_PATH", a.Configs.BlocklistPath).Send()
+	logger.Log.Info().Str("BLOCKLIST_RELOAD_INTERVAL", a.Configs.BlocklistReloadInterval.String()).Send()
+	logger.Log.Info().Int("REDIRECT_CODE", a.Configs.RedirectCode).Send()
+	logger.Log.Info().Str("PASSTHROUGH_QUERY_POLICY", a.Configs.PassthroughQueryPolicy).Send()
+	logger.Log.Info().Int("PASSWORD_MAX_ATTEMPTS", a.Configs.PasswordMaxAttempts).Send()
+	logger.Log.Info().Str("PASSWORD_ATTEMPT_WINDOW", a.Configs.PasswordAttemptWindow.String()).Send()
+	logger.Log.Info().Str("LINK_SIGNING_MODE", a.Configs.LinkSigningMode).Send()
+
+	if err := service.ValidateRedirectCode(a.Configs.RedirectCode); err != nil {
+		logger.Log.Error().Err(err).Msgf("falling back to default redirect code %d", config.RedirectCode)
+		a.Configs.RedirectCode = config.RedirectCode
+	}
+	if err := service.ValidateQueryPolicy(a.Configs.PassthroughQueryPolicy); err != nil {
+		logger.Log.Error().Err(err).Msgf("falling back to default query policy %s", config.PassthroughQueryPolicy)
+		a.Configs.PassthroughQueryPolicy = config.PassthroughQueryPolicy
+	}
+
+	// инициализация ключа подписи auth cookies
+	if a.Configs.SecretKey == "" {
+		logger.Log.Warn().Msg("SECRET_KEY is not set, auth cookies will be invalidated after restart")
+		key := make([]byte, secretKeyLen)
+		if _, err := rand.Read(key); err != nil {
+			logger.Log.Error().Stack().Err(err).Send()
+		}
+		a.Configs.SecretKey = hex.EncodeToString(key)
+	}
+}
 
+// InitServices инициализация хранилища и фоновых обработчиков. Возвращает ошибку, если
+// сервис, без которого сервер работает небезопасно, не удалось инициализировать
+func (a *Application) InitServices() error {
 	// инициализация хранилища
 	store, err := a.initStorage()
 	if err != nil {
-		logger.Log.Error().Stack().Err(err).Send()
+		return fmt.Errorf("error while initializing storage: %w", err)
 	}
 	a.Store = store
+
+	// инициализация подписи коротких ссылок
+	// без подписи ссылки режим required молча принимал бы неподписанные ссылки
+	signer, err := service.NewLinkSigner(a.Configs.LinkSigningMode, a.Configs.LinkSigningKeys)
+	if err != nil {
+		return fmt.Errorf("error while initializing link signer: %w", err)
+	}
+	a.Signer = signer
+
+	// инициализация защиты от цепочек редиректов через свои ссылки
+	guard, err := service.NewSelfLinkGuard(
+		a.Configs.BaseURL,
+		strings.Split(a.Configs.AliasHosts, ","),
+		a.Configs.SelfLinkPolicy,
+		a.Configs.MaxRedirectDepth,
+		a.Signer,
+	)
+	if err != nil {
+		return fmt.Errorf("error while initializing self link guard: %w", err)
+	}
+	a.SelfLinks = guard
+
+	// инициализация блоклиста урлов
+	bl, err := blocklist.New(a.Configs.BlocklistPath, a.Configs.BlocklistReloadInterval)
+	if err != nil {
+		return fmt.Errorf("error while initializing blocklist: %w", err)
+	}
+	a.Blocklist = bl
+
+	// инициализация ограничения попыток ввода пароля
+	a.PasswordAttempts = service.NewAttemptLimiter(a.Configs.PasswordMaxAttempts, a.Configs.PasswordAttemptWindow)
+
+	// инициализация фонового удаления урлов
+	a.Deleter = deleter.New(a.Store)
+	go a.Deleter.Run()
+
+	// инициализация фоновой записи статистики переходов
+	a.Recorder = recorder.New(a.Store)
+	go a.Recorder.Run()
+
+	return nil
 }
 
 // Run запуск сервера
@@ -71,24 +181,49 @@ func (a *Application) Run(h http.Handler) {
 	srv.Addr = a.Configs.RunAddr
 	srv.Handler = h
 
+	// фоновая очистка истекших урлов
+	if a.Configs.PurgeInterval > 0 {
+		a.Janitor = janitor.New(a.Store, a.Configs.PurgeInterval)
+		go a.Janitor.Run()
+	}
+
+	// перечитывание блоклиста при изменении файла и по SIGHUP
+	go a.Blocklist.Run()
+
 	err := srv.ListenAndServe()
 
-	if !errors.Is(err, http.ErrServerClosed) {
+	// ListenAndServe возвращается сразу после начала Shutdown, а обработчики еще могут
+	// записывать переходы и ставить урлы на удаление. Фоновые обработчики и хранилище
+	// закрываются только после того, как Shutdown дождется завершения запросов
+	if errors.Is(err, http.ErrServerClosed) {
+		<-idleConnsClosed
+		logger.Log.Info().Msg("server is closed")
+	} else {
 		logger.Log.Error().Stack().Err(err).Send()
 	}
 
+	if a.Janitor != nil {
+		a.Janitor.Close()
+		logger.Log.Info().Msg("janitor is stopped")
+	}
+
+	a.Blocklist.Close()
+
+	a.Deleter.Close()
+	logger.Log.Info().Msg("pending deletions are flushed")
+
+	a.Recorder.Close()
+	logger.Log.Info().Msg("pending click events are flushed")
+
 	s, ok := a.Store.(storage.StorageWithService)
 	if ok {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 		if err := s.Close(ctx); err != nil {
-			logger.Log.Error().Stack().Err(err).Msg("error while closing db connection")
+			logger.Log.Error().Stack().Err(err).Msg("error while closing storage")
 		}
-		logger.Log.Info().Msg("db connection is closed")
+		logger.Log.Info().Msg("storage is closed")
 	}
-
-	<-idleConnsClosed
-	logger.Log.Info().Msg("server is closed")
 }
 
 func (a *Application) initStorage() (storage.Storage, error) {
@@ -97,28 +232,91 @@ func (a *Application) initStorage() (storage.Storage, error) {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		conn, err := pgx.Connect(ctx, a.Configs.DatabaseDSN)
+		pool, err := a.newDBPool(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		gen, err := a.newIDGenerator(storage.NewDBSequence(pool))
 		if err != nil {
-			return nil, fmt.Errorf("error while connecting to db: %w", err)
+			pool.Close()
+			return nil, err
 		}
 
-		s := storage.NewDBStorage(conn)
+		s := storage.NewDBStorage(pool, gen)
 
 		if err = s.Bootstrap(ctx); err != nil {
+			pool.Close()
 			return nil, fmt.Errorf("error while creating tables in db: %w", err)
 		}
 
 		return s, nil
 
 	case a.Configs.FileStoragePath != "":
-		s, err := storage.NewFileStorage(a.Configs.FileStoragePath)
+		gen, err := a.newIDGenerator(service.NewMemSequence())
+		if err != nil {
+			return nil, err
+		}
+
+		s, err := storage.NewFileStorage(
+			a.Configs.FileStoragePath,
+			storage.SyncPolicy(a.Configs.FileSyncPolicy),
+			a.Configs.FileSyncInterval,
+			gen,
+		)
 		if err != nil {
 			return nil, fmt.Errorf("error while creating file storage: %w", err)
 		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err = s.Bootstrap(ctx); err != nil {
+			return nil, fmt.Errorf("error while loading file storage: %w", err)
+		}
 		return s, nil
 
 	default:
-		s := storage.NewMemStorage()
+		gen, err := a.newIDGenerator(service.NewMemSequence())
+		if err != nil {
+			return nil, err
+		}
+
+		s := storage.NewMemStorage(gen)
 		return s, nil
 	}
 }
+
+// newIDGenerator создает генератор коротких идентификаторов по стратегии из конфигов.
+// seq используется счетными стратегиями
+func (a *Application) newIDGenerator(seq service.Sequence) (service.IDGenerator, error) {
+	gen, err := service.NewIDGenerator(a.Configs.IDStrategy, a.Configs.IDLength, a.Configs.IDSalt, seq)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating id generator: %w", err)
+	}
+	return gen, nil
+}
+
+// newDBPool создает пул соединений с бд по настройкам из конфигов
+func (a *Application) newDBPool(ctx context.Context) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(a.Configs.DatabaseDSN)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing db config: %w", err)
+	}
+	poolConfig.MaxConns = int32(a.Configs.DBMaxConns)
+	poolConfig.MinConns = int32(a.Configs.DBMinConns)
+	poolConfig.MaxConnLifetime = a.Configs.DBMaxConnLifetime
+	poolConfig.HealthCheckPeriod = a.Configs.DBHealthCheckPeriod
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error while connecting to db: %w", err)
+	}
+
+	if err = pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("error while pinging db: %w", err)
+	}
+
+	return pool, nil
+}
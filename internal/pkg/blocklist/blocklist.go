@@ -0,0 +1,202 @@
+package blocklist
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/idna"
+
+	"github.com/nartim88/urlshortener/internal/pkg/logger"
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+// Виды правил блоклиста
+const (
+	// KindDomain правило блокирует домен и все его поддомены
+	KindDomain = "domain"
+	// KindPattern правило блокирует урлы, подходящие под регулярное выражение
+	KindPattern = "pattern"
+)
+
+// patternPrefix префикс строки файла с регулярным выражением
+const patternPrefix = "re:"
+
+// BlockedError урл подпадает под правило блоклиста
+type BlockedError struct {
+	URL  models.FullURL
+	Kind string
+	Rule string
+}
+
+func (e BlockedError) Error() string {
+	return fmt.Sprintf("url '%s' is blocked by %s rule '%s'", e.URL, e.Kind, e.Rule)
+}
+
+// rules правила, загруженные из файла
+type rules struct {
+	domains  map[string]struct{}
+	patterns []*regexp.Regexp
+}
+
+// Blocklist проверяет урлы по правилам из файла. Файл содержит по правилу в строке:
+// домен, блокирующий себя и свои поддомены, или регулярное выражение с префиксом
+// re:, применяемое к урлу целиком. Пустые строки и строки, начинающиеся с #,
+// пропускаются. Правила перечитываются при изменении файла и по SIGHUP
+type Blocklist struct {
+	path     string
+	interval time.Duration
+	rules    atomic.Pointer[rules]
+	modTime  time.Time
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+// New инициализирует Blocklist и загружает правила из файла path. Пустой path
+// дает блоклист без правил. interval задает период проверки изменения файла
+// и должен быть положительным, если файл задан
+func New(path string, interval time.Duration) (*Blocklist, error) {
+	b := Blocklist{
+		path:     path,
+		interval: interval,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	b.rules.Store(&rules{})
+	if path == "" {
+		return &b, nil
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("reload interval must be positive, got %s", interval)
+	}
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Check возвращает BlockedError, если урл подпадает под одно из правил
+func (b *Blocklist) Check(fURL models.FullURL) error {
+	r := b.rules.Load()
+
+	if u, err := url.Parse(string(fURL)); err == nil {
+		host := strings.ToLower(u.Hostname())
+		for host != "" {
+			if _, ok := r.domains[host]; ok {
+				return BlockedError{fURL, KindDomain, host}
+			}
+			_, host, _ = strings.Cut(host, ".")
+		}
+	}
+
+	for _, re := range r.patterns {
+		if re.MatchString(string(fURL)) {
+			return BlockedError{fURL, KindPattern, re.String()}
+		}
+	}
+	return nil
+}
+
+// Reload перечитывает правила из файла. При ошибке остаются прежние правила
+func (b *Blocklist) Reload() error {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return fmt.Errorf("error while opening blocklist: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("error while reading blocklist: %w", err)
+	}
+	// файл с ошибкой не перечитывается повторно, пока не изменится
+	b.modTime = info.ModTime()
+
+	r := rules{domains: make(map[string]struct{})}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if pattern, ok := strings.CutPrefix(line, patternPrefix); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("blocklist line %d: %w", n, err)
+			}
+			r.patterns = append(r.patterns, re)
+			continue
+		}
+
+		domain, err := idna.Lookup.ToASCII(strings.TrimPrefix(strings.TrimPrefix(line, "*"), "."))
+		if err != nil || domain == "" {
+			return fmt.Errorf("blocklist line %d: invalid domain '%s'", n, line)
+		}
+		r.domains[domain] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error while reading blocklist: %w", err)
+	}
+
+	b.rules.Store(&r)
+	logger.Log.Info().
+		Int("domains", len(r.domains)).
+		Int("patterns", len(r.patterns)).
+		Msg("blocklist is loaded")
+	return nil
+}
+
+// Run перечитывает правила при изменении файла и по SIGHUP, пока не будет вызван Close
+func (b *Blocklist) Run() {
+	defer close(b.done)
+	if b.path == "" {
+		<-b.quit
+		return
+	}
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(b.path)
+			if err != nil {
+				logger.Log.Error().Err(err).Msg("error while checking blocklist")
+				continue
+			}
+			if info.ModTime().Equal(b.modTime) {
+				continue
+			}
+			b.reload()
+		case <-sighup:
+			b.reload()
+		case <-b.quit:
+			return
+		}
+	}
+}
+
+// Close останавливает Run
+func (b *Blocklist) Close() {
+	close(b.quit)
+	<-b.done
+}
+
+func (b *Blocklist) reload() {
+	if err := b.Reload(); err != nil {
+		logger.Log.Error().Err(err).Msg("error while reloading blocklist, keeping previous rules")
+	}
+}
@@ -0,0 +1,73 @@
+package blocklist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+func TestBlocklistCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	data := "# phishing\n\nevil.com\n*.пример.рф\nre:^https?://[^/]+/(login|signin)\\.php\n"
+	require.NoError(t, os.WriteFile(path, []byte(data), 0666))
+
+	b, err := New(path, time.Second)
+	require.NoError(t, err)
+
+	var testCases = []struct {
+		name string
+		fURL models.FullURL
+		want error
+	}{
+		{name: "domain", fURL: "https://evil.com/page", want: BlockedError{"https://evil.com/page", KindDomain, "evil.com"}},
+		{name: "subdomain", fURL: "https://login.evil.com:8443/", want: BlockedError{"https://login.evil.com:8443/", KindDomain, "evil.com"}},
+		{name: "idn", fURL: "https://xn--e1afmkfd.xn--p1ai/", want: BlockedError{"https://xn--e1afmkfd.xn--p1ai/", KindDomain, "xn--e1afmkfd.xn--p1ai"}},
+		{name: "pattern", fURL: "https://bank.example/login.php", want: BlockedError{"https://bank.example/login.php", KindPattern, `^https?://[^/]+/(login|signin)\.php`}},
+		{name: "similar_domain", fURL: "https://notevil.com/"},
+		{name: "allowed", fURL: "https://ya.ru/help/login.php"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, b.Check(tc.fURL))
+		})
+	}
+
+	t.Run("empty_path", func(t *testing.T) {
+		b, err := New("", time.Second)
+		require.NoError(t, err)
+		assert.NoError(t, b.Check("https://evil.com"))
+	})
+
+	t.Run("non_positive_interval", func(t *testing.T) {
+		_, err := New(path, 0)
+		assert.Error(t, err)
+	})
+}
+
+func TestBlocklistReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(path, []byte("evil.com\n"), 0666))
+
+	b, err := New(path, 10*time.Millisecond)
+	require.NoError(t, err)
+	go b.Run()
+	defer b.Close()
+
+	require.NoError(t, os.WriteFile(path, []byte("re:[\n"), 0666))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	time.Sleep(50 * time.Millisecond)
+	assert.Error(t, b.Check("https://evil.com"), "invalid file keeps previous rules")
+
+	require.NoError(t, os.WriteFile(path, []byte("bad.org\n"), 0666))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second)))
+	assert.Eventually(t, func() bool {
+		return b.Check("https://evil.com") == nil && b.Check("https://bad.org") != nil
+	}, time.Second, 10*time.Millisecond)
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"flag"
+	"time"
 
 	"github.com/caarlos0/env"
 	"github.com/joho/godotenv"
@@ -15,6 +16,43 @@ type Config struct {
 	LogLevel        string `env:"LOG_LEVEL"`
 	FileStoragePath string `env:"FILE_STORAGE_PATH"`
 	DatabaseDSN     string `env:"DATABASE_DSN"`
+	SecretKey       string `env:"SECRET_KEY"`
+	// стратегия генерации коротких идентификаторов: random, counter, hash или obfuscated
+	IDStrategy string `env:"ID_STRATEGY"`
+	IDLength   int    `env:"ID_LENGTH"`
+	IDSalt     string `env:"ID_SALT"`
+	// политика fsync файлового хранилища: always, interval или never
+	FileSyncPolicy   string        `env:"FILE_SYNC_POLICY"`
+	FileSyncInterval time.Duration `env:"FILE_SYNC_INTERVAL"`
+	// настройки пула соединений с бд
+	DBMaxConns          int           `env:"DB_MAX_CONNS"`
+	DBMinConns          int           `env:"DB_MIN_CONNS"`
+	DBMaxConnLifetime   time.Duration `env:"DB_MAX_CONN_LIFETIME"`
+	DBHealthCheckPeriod time.Duration `env:"DB_HEALTH_CHECK_PERIOD"`
+	// период очистки истекших урлов, 0 отключает очистку
+	PurgeInterval time.Duration `env:"PURGE_INTERVAL"`
+	// дополнительные хосты сервиса через запятую, ссылки на них считаются своими
+	AliasHosts string `env:"ALIAS_HOSTS"`
+	// политика обработки урлов на свои короткие ссылки: reject или resolve
+	SelfLinkPolicy string `env:"SELF_LINK_POLICY"`
+	// максимальная глубина цепочки своих коротких ссылок
+	MaxRedirectDepth int `env:"MAX_REDIRECT_DEPTH"`
+	// файл с правилами блоклиста урлов и период проверки его изменения
+	BlocklistPath           string        `env:"BLOCKLIST_PATH"`
+	BlocklistReloadInterval time.Duration `env:"BLOCKLIST_RELOAD_INTERVAL"`
+	// код ответа при переходе по урлу без своего кода редиректа
+	RedirectCode int `env:"REDIRECT_CODE"`
+	// политика слияния параметров запроса с параметрами урла в режиме passthrough:
+	// target, request или both
+	PassthroughQueryPolicy string `env:"PASSTHROUGH_QUERY_POLICY"`
+	// допустимое число неверных паролей к защищенному урлу за окно
+	PasswordMaxAttempts   int           `env:"PASSWORD_MAX_ATTEMPTS"`
+	PasswordAttemptWindow time.Duration `env:"PASSWORD_ATTEMPT_WINDOW"`
+	// режим подписи коротких ссылок: off, optional или required
+	LinkSigningMode string `env:"LINK_SIGNING_MODE"`
+	// ключи подписи ссылок парами key_id:secret через запятую, новые ссылки
+	// подписываются первым, остальные остаются для проверки выданных ссылок
+	LinkSigningKeys string `env:"LINK_SIGNING_KEYS"`
 }
 
 // NewConfig инициализирует Config с дефолтными значениями
@@ -48,7 +86,29 @@ func (conf *Config) parseFlags() {
 	flag.StringVar(&conf.BaseURL, "b", BaseURL, "server address before shorten URL")
 	flag.StringVar(&conf.LogLevel, "l", LogLevel, "log level")
 	flag.StringVar(&conf.FileStoragePath, "f", "", "full file name for saving URLs")
+	flag.StringVar(&conf.FileSyncPolicy, "file-sync-policy", FileSyncPolicy, "file storage fsync policy: always, interval or never")
+	flag.DurationVar(&conf.FileSyncInterval, "file-sync-interval", FileSyncInterval, "file storage fsync period for interval policy")
 	flag.StringVar(&conf.DatabaseDSN, "d", "", "database DSN")
+	flag.StringVar(&conf.SecretKey, "k", "", "secret key for signing auth cookies")
+	flag.StringVar(&conf.IDStrategy, "id-strategy", IDStrategy, "short id generation strategy: random, counter, hash or obfuscated")
+	flag.IntVar(&conf.IDLength, "id-length", IDLength, "short id length")
+	flag.StringVar(&conf.IDSalt, "id-salt", "", "salt for obfuscated short id strategy")
+	flag.IntVar(&conf.DBMaxConns, "db-max-conns", DBMaxConns, "max number of connections in db pool")
+	flag.IntVar(&conf.DBMinConns, "db-min-conns", DBMinConns, "min number of connections in db pool")
+	flag.DurationVar(&conf.DBMaxConnLifetime, "db-max-conn-lifetime", DBMaxConnLifetime, "max lifetime of db connection")
+	flag.DurationVar(&conf.DBHealthCheckPeriod, "db-health-check-period", DBHealthCheckPeriod, "period of db pool health check")
+	flag.DurationVar(&conf.PurgeInterval, "purge-interval", PurgeInterval, "period of expired urls purging, 0 disables it")
+	flag.StringVar(&conf.AliasHosts, "alias-hosts", "", "comma separated extra hosts of the service")
+	flag.StringVar(&conf.SelfLinkPolicy, "self-link-policy", SelfLinkPolicy, "policy for urls pointing to own short links: reject or resolve")
+	flag.IntVar(&conf.MaxRedirectDepth, "max-redirect-depth", MaxRedirectDepth, "max depth of own short links chain")
+	flag.StringVar(&conf.BlocklistPath, "blocklist", "", "file with blocked domains and url patterns")
+	flag.DurationVar(&conf.BlocklistReloadInterval, "blocklist-reload-interval", BlocklistReloadInterval, "period of checking blocklist file for changes")
+	flag.IntVar(&conf.RedirectCode, "redirect-code", RedirectCode, "default redirect status code: 301, 302, 303, 307 or 308")
+	flag.StringVar(&conf.PassthroughQueryPolicy, "passthrough-query-policy", PassthroughQueryPolicy, "which query value wins on conflict in passthrough mode: target, request or both")
+	flag.IntVar(&conf.PasswordMaxAttempts, "password-max-attempts", PasswordMaxAttempts, "max wrong passwords to a protected link per window")
+	flag.DurationVar(&conf.PasswordAttemptWindow, "password-attempt-window", PasswordAttemptWindow, "window of counting wrong passwords to a protected link")
+	flag.StringVar(&conf.LinkSigningMode, "link-signing-mode", LinkSigningMode, "short link signing mode: off, optional or required")
+	flag.StringVar(&conf.LinkSigningKeys, "link-signing-keys", "", "short link signing keys as key_id:secret pairs separated by commas, the first one signs new links")
 
 	flag.Parse()
 }
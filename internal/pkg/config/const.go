@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 // DB constants
 const (
 	DBTableName   = "shortener"
@@ -14,3 +16,56 @@ const (
 	FileStoragePath = "/tmp/short-url-db.json"
 	DatabaseDSN     = "host=localhost user=videos password=videos dbname=videos"
 )
+
+// Short ID constants
+const (
+	IDStrategy = "random"
+	IDLength   = 8
+)
+
+// File storage constants
+const (
+	FileSyncPolicy   = "interval"
+	FileSyncInterval = time.Second
+)
+
+// DB pool constants
+const (
+	DBMaxConns          = 10
+	DBMinConns          = 0
+	DBMaxConnLifetime   = time.Hour
+	DBHealthCheckPeriod = time.Minute
+)
+
+// Expiration constants
+const (
+	PurgeInterval = time.Minute
+)
+
+// Self link constants
+const (
+	SelfLinkPolicy   = "reject"
+	MaxRedirectDepth = 5
+)
+
+// Blocklist constants
+const (
+	BlocklistReloadInterval = 10 * time.Second
+)
+
+// Redirect constants
+const (
+	RedirectCode           = 307
+	PassthroughQueryPolicy = "target"
+)
+
+// Password constants
+const (
+	PasswordMaxAttempts   = 5
+	PasswordAttemptWindow = 15 * time.Minute
+)
+
+// Link signing constants
+const (
+	LinkSigningMode = "off"
+)
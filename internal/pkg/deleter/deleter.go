@@ -0,0 +1,105 @@
+package deleter
+
+import (
+	"context"
+	"time"
+
+	"github.com/nartim88/urlshortener/internal/pkg/logger"
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+	"github.com/nartim88/urlshortener/internal/pkg/storage"
+)
+
+const (
+	// batchSize количество задач, при накоплении которого пачка удаляется сразу
+	batchSize = 100
+	// flushInterval максимальное время ожидания задач перед удалением неполной пачки
+	flushInterval = 5 * time.Second
+	flushTimeout  = 30 * time.Second
+)
+
+// Deleter в фоне удаляет урлы пользователей, собирая задачи из всех запросов
+// в общий канал и отправляя их в хранилище пачками
+type Deleter struct {
+	store storage.Storage
+	tasks chan models.DeleteTask
+	quit  chan struct{}
+	done  chan struct{}
+}
+
+// New инициализирует Deleter для хранилища store
+func New(store storage.Storage) *Deleter {
+	return &Deleter{
+		store: store,
+		tasks: make(chan models.DeleteTask, batchSize),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Push ставит в очередь удаление урлов пользователя uID, не дожидаясь самого удаления
+func (d *Deleter) Push(uID models.UserID, sIDs []models.ShortenID) {
+	go func() {
+		for _, sID := range sIDs {
+			select {
+			case d.tasks <- models.DeleteTask{UserID: uID, ShortenID: sID}:
+			case <-d.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Run обрабатывает очередь, пока не будет вызван Close
+func (d *Deleter) Run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.DeleteTask, 0, batchSize)
+
+	for {
+		select {
+		case t := <-d.tasks:
+			batch = append(batch, t)
+			if len(batch) >= batchSize {
+				batch = d.flush(batch)
+			}
+		case <-ticker.C:
+			batch = d.flush(batch)
+		case <-d.quit:
+			for {
+				select {
+				case t := <-d.tasks:
+					batch = append(batch, t)
+				default:
+					d.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close останавливает Run, удалив накопленные задачи
+func (d *Deleter) Close() {
+	close(d.quit)
+	<-d.done
+}
+
+// flush удаляет пачку урлов и возвращает пустой слайс для накопления следующей
+func (d *Deleter) flush(batch []models.DeleteTask) []models.DeleteTask {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	if err := d.store.DeleteURLs(ctx, batch); err != nil {
+		logger.Log.Error().Err(err).Int("count", len(batch)).Msg("error while deleting urls")
+	} else {
+		logger.Log.Info().Int("count", len(batch)).Msg("urls are deleted")
+	}
+	return batch[:0]
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/nartim88/urlshortener/internal/app/shortener"
+	"github.com/nartim88/urlshortener/internal/pkg/logger"
+	"github.com/nartim88/urlshortener/internal/pkg/middleware"
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+	"github.com/nartim88/urlshortener/internal/pkg/models/api"
+	"github.com/nartim88/urlshortener/internal/pkg/models/api/edit"
+	"github.com/nartim88/urlshortener/internal/pkg/service"
+	"github.com/nartim88/urlshortener/internal/pkg/storage"
+)
+
+// EditURLHandle меняет целевой урл и параметры урла текущего пользователя.
+// Поля, которых нет в запросе, не меняются. Изменение записывается в историю урла,
+// а запрос, который ничего не меняет, отклоняется
+func EditURLHandle(w http.ResponseWriter, r *http.Request) {
+	uID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	sID, ok := verifyShortenID(w, r)
+	if !ok {
+		return
+	}
+
+	var req edit.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Log.Info().Err(err).Send()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.OneTime.Get() {
+		opts := models.URLOptions{MaxClicks: req.MaxClicks.Get()}
+		if !setOneTime(w, &opts) {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	// целевой урл нельзя сбросить, так что пустой урл и null отклоняются как невалидный урл
+	var fURL models.FullURL
+	if req.FullURL.Set {
+		if fURL, ok = normalizeURL(w, string(req.FullURL.Get())); !ok {
+			return
+		}
+		if err := checkEditedURL(ctx, fURL); err != nil {
+			logger.Log.Info().Err(err).Send()
+			writeSetError(w, err)
+			return
+		}
+	}
+
+	// пароль хэшируется до изменения, чтобы bcrypt не работал под блокировкой урла
+	var passwordHash string
+	if req.Password.Set {
+		var err error
+		if passwordHash, err = service.HashPassword(req.Password.Get()); err != nil {
+			logger.Log.Info().Err(err).Send()
+			writeJSONError(w, http.StatusBadRequest, api.ErrCodeInvalidPassword, err.Error())
+			return
+		}
+	}
+
+	change, err := shortener.App.Store.UpdateURL(ctx, uID, sID, func(t *models.Target) error {
+		applyEdit(t, req, fURL, passwordHash)
+
+		// срок жизни проверяется, только если он меняется: истекший урл можно
+		// изменить, не продлевая его
+		opts := t.URLOptions
+		if !req.ExpiresAt.Set {
+			opts.ExpiresAt = nil
+		}
+		return checkURLOptions(opts, time.Now())
+	})
+	if err != nil {
+		writeEditError(w, err)
+		return
+	}
+
+	respDecoded, err := json.Marshal(changePayload(*change))
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while serializing response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(contentType, applicationJSON)
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(respDecoded); err != nil {
+		logger.Log.Info().Err(err).Msg("error while sending response")
+	}
+}
+
+// GetURLHistoryHandle возвращает историю изменений урла текущего пользователя
+func GetURLHistoryHandle(w http.ResponseWriter, r *http.Request) {
+	uID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	sID, ok := verifyShortenID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	history, err := shortener.App.Store.GetURLHistory(ctx, uID, sID)
+	if err != nil {
+		writeEditError(w, err)
+		return
+	}
+	if len(history) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	resp := make([]edit.Change, 0, len(history))
+	for _, change := range history {
+		resp = append(resp, changePayload(change))
+	}
+
+	respDecoded, err := json.Marshal(resp)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while serializing response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(contentType, applicationJSON)
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(respDecoded); err != nil {
+		logger.Log.Info().Err(err).Msg("error while sending response")
+	}
+}
+
+// applyEdit применяет к цели урла поля, которые есть в запросе. fURL и passwordHash —
+// уже проверенный урл и хэш пароля из запроса
+func applyEdit(t *models.Target, req edit.Request, fURL models.FullURL, passwordHash string) {
+	if req.FullURL.Set {
+		t.FullURL = fURL
+	}
+	if req.ExpiresAt.Set {
+		t.ExpiresAt = req.ExpiresAt.Value
+	}
+	if req.MaxClicks.Set {
+		t.MaxClicks = req.MaxClicks.Get()
+	}
+	if req.OneTime.Get() {
+		t.MaxClicks = 1
+	}
+	if req.RedirectCode.Set {
+		t.RedirectCode = req.RedirectCode.Get()
+	}
+	if req.Passthrough.Set {
+		t.Passthrough = req.Passthrough.Get()
+	}
+	if req.UTM.Set {
+		t.UTM = nil
+		if utm := req.UTM.Get(); !utm.IsZero() {
+			t.UTM = &utm
+		}
+	}
+	if req.Password.Set {
+		t.PasswordHash = passwordHash
+	}
+}
+
+// checkEditedURL проверяет новую цель урла. Кроме блоклиста, урл не может вести
+// на короткую ссылку сервиса ни при какой политике: изменение не создает новую
+// ссылку, которую можно было бы заменить уже существующей
+func checkEditedURL(ctx context.Context, fURL models.FullURL) error {
+	if err := shortener.App.Blocklist.Check(fURL); err != nil {
+		return err
+	}
+	sID, err := shortener.App.SelfLinks.Check(ctx, shortener.App.Store, fURL)
+	if err != nil {
+		return err
+	}
+	if sID != "" {
+		return service.SelfLinkError{URL: fURL, Reason: "url points to a short link of this service"}
+	}
+	return nil
+}
+
+// writeEditError отвечает клиенту ошибкой изменения урла или чтения его истории
+func writeEditError(w http.ResponseWriter, err error) {
+	var optErr optionsError
+	switch {
+	case errors.As(err, &optErr):
+		writeOptionsError(w, optErr)
+	case errors.Is(err, storage.ErrURLNotModified):
+		writeJSONError(w, http.StatusBadRequest, api.ErrCodeNotModified, "request does not change the url")
+	case errors.Is(err, storage.ErrURLNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, storage.ErrNotOwner):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, storage.ErrURLDeleted), errors.Is(err, storage.ErrURLExpired):
+		w.WriteHeader(http.StatusGone)
+	default:
+		logger.Log.Error().Err(err).Msg("error while editing url")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// changePayload возвращает изменение урла в виде для клиента
+func changePayload(change models.URLChange) edit.Change {
+	return edit.Change{
+		UserID:    change.UserID,
+		ChangedAt: change.ChangedAt,
+		Old:       targetPayload(change.Old),
+		New:       targetPayload(change.New),
+	}
+}
+
+// targetPayload возвращает цель урла в виде для клиента, без хэша пароля
+func targetPayload(t models.Target) edit.Target {
+	return edit.Target{
+		FullURL:           t.FullURL,
+		ExpiresAt:         t.ExpiresAt,
+		MaxClicks:         t.MaxClicks,
+		RedirectCode:      t.RedirectCode,
+		Passthrough:       t.Passthrough,
+		UTM:               t.UTM,
+		PasswordProtected: t.PasswordHash != "",
+	}
+}
@@ -5,17 +5,26 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/jackc/pgx/v5"
 	"github.com/nartim88/urlshortener/internal/app/shortener"
+	"github.com/nartim88/urlshortener/internal/pkg/blocklist"
 	"github.com/nartim88/urlshortener/internal/pkg/logger"
+	"github.com/nartim88/urlshortener/internal/pkg/middleware"
 	"github.com/nartim88/urlshortener/internal/pkg/models"
+	"github.com/nartim88/urlshortener/internal/pkg/models/api"
+	"github.com/nartim88/urlshortener/internal/pkg/models/api/stats"
+	"github.com/nartim88/urlshortener/internal/pkg/models/api/user"
 	"github.com/nartim88/urlshortener/internal/pkg/models/api/v1"
 	v2 "github.com/nartim88/urlshortener/internal/pkg/models/api/v2"
+	"github.com/nartim88/urlshortener/internal/pkg/service"
 	"github.com/nartim88/urlshortener/internal/pkg/storage"
 )
 
@@ -25,6 +34,9 @@ const (
 	applicationJSON = "application/json"
 )
 
+// permanentRedirectMaxAge максимальное время кэширования постоянного редиректа клиентом
+const permanentRedirectMaxAge = 24 * time.Hour
+
 // IndexHandle возвращает короткий УРЛ
 func IndexHandle(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
@@ -43,12 +55,28 @@ func IndexHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fURL := models.FullURL(body)
+	fURL, ok := normalizeURL(w, string(body))
+	if !ok {
+		return
+	}
+	uID, _ := middleware.UserIDFromContext(r.Context())
+
+	opts := models.SetOptions{Alias: models.ShortenID(r.URL.Query().Get("alias"))}
+	if !validateAlias(w, opts.Alias) {
+		return
+	}
+	if oneTime, _ := strconv.ParseBool(r.URL.Query().Get("one_time")); oneTime && !setOneTime(w, &opts.URLOptions) {
+		return
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	sID, err := shortener.App.Store.Set(ctx, fURL)
+	if !applyUTMDefaults(ctx, w, uID, &opts.URLOptions) {
+		return
+	}
+
+	sID, err := setURL(ctx, fURL, uID, opts)
 	sCode := http.StatusCreated
 	if err != nil {
 		var existsErr storage.URLExistsError
@@ -58,46 +86,107 @@ func IndexHandle(w http.ResponseWriter, r *http.Request) {
 			sCode = http.StatusConflict
 		} else {
 			logger.Log.Info().Err(err).Send()
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeSetError(w, err)
 			return
 		}
 	}
 
-	shortURL := shortener.App.Configs.BaseURL + "/" + string(*sID)
-
 	w.Header().Set(contentType, textPlain)
 	w.WriteHeader(sCode)
-	_, err = w.Write([]byte(shortURL))
+	_, err = w.Write([]byte(shortURL(*sID)))
 
 	if err != nil {
 		logger.Log.Info().Err(err).Send()
 	}
 }
 
-// GetURLHandle возвращает полный УРЛ по короткому
+// GetURLHandle возвращает полный УРЛ по короткому. Переход по урлу с паролем
+// выполняется только после проверки пароля, переданного в заголовке или формой
 func GetURLHandle(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	sID := models.ShortenID(id)
+	sID, ok := verifyShortenID(w, r)
+	if !ok {
+		return
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	fURL, err := shortener.App.Store.Get(ctx, sID)
+	target, err := shortener.App.Store.Lookup(ctx, sID)
+	if !checkTarget(w, target, err) {
+		return
+	}
 
-	if err != nil {
-		logger.Log.Info().Err(err).Send()
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	protected := target.PasswordHash != ""
+	if protected && !verifyLinkPassword(w, r, sID, target.PasswordHash) {
 		return
 	}
 
-	if fURL == nil {
+	// суффикс пути после идентификатора переносится в целевой урл только в режиме passthrough
+	fURL := target.FullURL
+	_, suffix, _ := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	if target.Passthrough {
+		fURL, err = service.Passthrough(fURL, suffix, r.URL.Query(), shortener.App.Configs.PassthroughQueryPolicy)
+		if err != nil {
+			logger.Log.Info().Err(err).Send()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if suffix != "" {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	if target.UTM != nil {
+		if fURL, err = service.TagUTM(fURL, *target.UTM); err != nil {
+			logger.Log.Error().Err(err).Msg("error while adding utm parameters")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// цель ссылки могла попасть в блоклист уже после сокращения
+	var blockedErr blocklist.BlockedError
+	if errors.As(shortener.App.Blocklist.Check(fURL), &blockedErr) {
+		logger.Log.Info().Err(blockedErr).Send()
+		writeBlockedError(w, http.StatusUnavailableForLegalReasons, blockedErr)
+		return
+	}
+
+	// переход засчитывается только перед самим редиректом, чтобы неверный пароль
+	// или ошибка не гасили урл с лимитом. Hit атомарен, поэтому из конкурентных
+	// переходов по одноразовому урлу успешен только один, остальные получают 410
+	if target.MaxClicks > 0 {
+		if target, err = shortener.App.Store.Hit(ctx, sID); !checkTarget(w, target, err) {
+			return
+		}
+	}
+
+	now := time.Now()
+	shortener.App.Recorder.Record(models.ClickEvent{
+		ShortenID: sID,
+		Time:      now,
+		Referrer:  service.ReferrerHost(r.Referer()),
+		UserAgent: r.UserAgent(),
+		IPHash:    service.HashIP(r.RemoteAddr, shortener.App.Configs.SecretKey),
+	})
+
+	sCode := target.RedirectCode
+	if sCode == 0 {
+		sCode = shortener.App.Configs.RedirectCode
+	}
+	// после отправки формы с паролем браузер должен перейти по урлу методом GET
+	if r.Method == http.MethodPost {
+		sCode = http.StatusSeeOther
+	}
+
+	cacheControl := redirectCacheControl(sCode, target.URLOptions, now)
+	if protected {
+		cacheControl = "no-store"
+	}
 
-	w.Header().Set("Location", string(*fURL))
+	w.Header().Set("Location", string(fURL))
+	w.Header().Set("Cache-Control", cacheControl)
 	w.Header().Set(contentType, textPlain)
-	w.WriteHeader(http.StatusTemporaryRedirect)
+	w.WriteHeader(sCode)
 }
 
 func GetShortURLHandle(w http.ResponseWriter, r *http.Request) {
@@ -119,12 +208,31 @@ func GetShortURLHandle(w http.ResponseWriter, r *http.Request) {
 	}
 	logger.Log.Info().Str("original_url", string(req.FullURL)).Msg("incoming request data:")
 
+	fURL, ok := normalizeURL(w, string(req.FullURL))
+	if !ok || !validateAlias(w, req.Alias) || !validateURLOptions(w, req.URLOptions) {
+		return
+	}
+	if req.OneTime && !setOneTime(w, &req.URLOptions) {
+		return
+	}
+	if !hashLinkPassword(w, req.Password, &req.URLOptions) {
+		return
+	}
+
 	sCode := http.StatusCreated
+	uID, _ := middleware.UserIDFromContext(r.Context())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	sID, err := shortener.App.Store.Set(ctx, req.FullURL)
+	if !applyUTMDefaults(ctx, w, uID, &req.URLOptions) {
+		return
+	}
+
+	sID, err := setURL(ctx, fURL, uID, models.SetOptions{
+		Alias:      req.Alias,
+		URLOptions: req.URLOptions,
+	})
 	if err != nil {
 		var existsErr storage.URLExistsError
 		if errors.As(err, &existsErr) {
@@ -133,15 +241,14 @@ func GetShortURLHandle(w http.ResponseWriter, r *http.Request) {
 			sCode = http.StatusConflict
 		} else {
 			logger.Log.Info().Err(err).Send()
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeSetError(w, err)
 			return
 		}
 	}
 
-	shortURL := shortener.App.Configs.BaseURL + "/" + string(*sID)
 	resp := v1.Response{
 		Response: v1.ResponsePayload{
-			Result: shortURL,
+			Result: shortURL(*sID),
 		},
 	}
 
@@ -161,22 +268,22 @@ func GetShortURLHandle(w http.ResponseWriter, r *http.Request) {
 }
 
 func DBPingHandle(w http.ResponseWriter, r *http.Request) {
-	logger.Log.Info().Str("DATABASE_DSN", shortener.App.Configs.DatabaseDSN).Msg("trying to ping DB via")
+	s, ok := shortener.App.Store.(storage.StorageWithService)
+	if !ok {
+		err := errors.New("storage doesn't support ping")
+		logger.Log.Error().Err(err).Send()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	conn, err := pgx.Connect(ctx, shortener.App.Configs.DatabaseDSN)
-	if err != nil {
+	if err := s.Ping(ctx); err != nil {
 		logger.Log.Error().Stack().Err(err).Send()
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer func() {
-		if err := conn.Close(ctx); err != nil {
-			logger.Log.Error().Stack().Err(err).Send()
-		}
-	}()
 
 	logger.Log.Info().Msg("ping successfully processed")
 	w.WriteHeader(http.StatusOK)
@@ -198,34 +305,91 @@ func GetBatchShortURLsHandle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	logger.Log.Info().Msgf("got batch: %+v", req)
+	logger.Log.Info().Int("items", len(req.Data)).Msg("got batch")
 
-	var respPayload []v2.ResponsePayload
+	uID, _ := middleware.UserIDFromContext(r.Context())
 
-	sCode := http.StatusCreated
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	for _, rData := range req.Data {
-		sID, err := shortener.App.Store.Set(ctx, rData.FullURL)
-		if err != nil {
-			var existsErr storage.URLExistsError
-			if errors.As(err, &existsErr) {
-				logger.Log.Info().Msgf("%v", existsErr)
-				sID = &existsErr.SID
-				sCode = http.StatusConflict
-			} else {
-				logger.Log.Info().Err(err).Send()
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-		}
-		shortURL := shortener.App.Configs.BaseURL + "/" + string(*sID)
-		respPayload = append(respPayload, v2.ResponsePayload{
-			CorrelationID: rData.CorrelationID,
-			ShortURL:      shortURL,
+	utmDefaults, err := shortener.App.Store.GetUTMDefaults(ctx, uID)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while getting utm defaults")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respPayload := make([]v2.ResponsePayload, len(req.Data))
+
+	// элементы, не прошедшие проверку, заблокированные или ведущие на свои
+	// короткие ссылки, в хранилище не отправляются
+	items := make([]models.BatchItem, 0, len(req.Data))
+	itemIdx := make([]int, 0, len(req.Data))
+	for i, rData := range req.Data {
+		respPayload[i].CorrelationID = rData.CorrelationID
+		if err = validateBatchItem(&rData); err != nil {
+			respPayload[i].Status = v2.StatusInvalid
+			respPayload[i].Error = err.Error()
+			continue
+		}
+		var blockedErr blocklist.BlockedError
+		if errors.As(shortener.App.Blocklist.Check(rData.FullURL), &blockedErr) {
+			setBlockedPayload(&respPayload[i], blockedErr)
+			continue
+		}
+		if resolveBatchSelfLink(ctx, rData.FullURL, &respPayload[i]) {
+			continue
+		}
+		rData.UTM = rData.UTM.WithDefaults(utmDefaults)
+		items = append(items, models.BatchItem{
+			FullURL: rData.FullURL,
+			SetOptions: models.SetOptions{
+				Alias:      rData.Alias,
+				URLOptions: rData.URLOptions,
+			},
 		})
+		itemIdx = append(itemIdx, i)
+	}
+
+	results, err := shortener.App.Store.SetBatch(ctx, uID, items)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while saving batch")
+	}
+
+	for j, i := range itemIdx {
+		payload := &respPayload[i]
+		if err != nil {
+			payload.Status = v2.StatusError
+			payload.Error = err.Error()
+			continue
+		}
+
+		res := results[j]
+		var aliasErr storage.AliasExistsError
+		switch {
+		case errors.As(res.Err, &aliasErr):
+			payload.Status = v2.StatusInvalid
+			payload.Error = res.Err.Error()
+		case res.Err != nil:
+			payload.Status = v2.StatusError
+			payload.Error = res.Err.Error()
+		case res.Created:
+			payload.Status = v2.StatusCreated
+		default:
+			payload.Status = v2.StatusExists
+		}
+		if res.Err == nil {
+			payload.ShortURL = shortURL(res.ShortenID)
+		}
+	}
+
+	// 201 только если сохранены все элементы, иначе клиент разбирает статусы элементов
+	sCode := http.StatusCreated
+	for _, payload := range respPayload {
+		if payload.Status != v2.StatusCreated {
+			sCode = http.StatusMultiStatus
+			break
+		}
 	}
 
 	resp := v2.Response{Response: respPayload}
@@ -245,3 +409,510 @@ func GetBatchShortURLsHandle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
+
+// GetUserURLsHandle возвращает все урлы, сокращенные текущим пользователем
+func GetUserURLsHandle(w http.ResponseWriter, r *http.Request) {
+	uID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	urls, err := shortener.App.Store.GetUserURLs(ctx, uID)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while getting user urls")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(urls) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var respPayload []user.URLsResponsePayload
+	for _, u := range urls {
+		respPayload = append(respPayload, user.URLsResponsePayload{
+			ShortURL: shortURL(u.ShortenID),
+			FullURL:  u.FullURL,
+		})
+	}
+
+	resp := user.URLsResponse{Response: respPayload}
+
+	respDecoded, err := json.Marshal(resp.Response)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while serializing response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(contentType, applicationJSON)
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(respDecoded)
+	if err != nil {
+		logger.Log.Info().Err(err).Msg("error while sending response")
+	}
+}
+
+// DeleteUserURLsHandle ставит в очередь удаление урлов текущего пользователя
+func DeleteUserURLsHandle(w http.ResponseWriter, r *http.Request) {
+	uID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var req user.DeleteRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req.Data); err != nil {
+		logger.Log.Info().Err(err).Msg("error while deserializing json")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// в запросе могут быть как идентификаторы, так и подписанные ссылки
+	for i, id := range req.Data {
+		if sID, err := shortener.App.Signer.Verify(string(id)); err == nil {
+			req.Data[i] = sID
+		}
+	}
+
+	shortener.App.Deleter.Push(uID, req.Data)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// GetUTMDefaultsHandle возвращает UTM метки текущего пользователя по умолчанию
+func GetUTMDefaultsHandle(w http.ResponseWriter, r *http.Request) {
+	uID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	utm, err := shortener.App.Store.GetUTMDefaults(ctx, uID)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while getting utm defaults")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if utm == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	respDecoded, err := json.Marshal(utm)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while serializing response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(contentType, applicationJSON)
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(respDecoded); err != nil {
+		logger.Log.Info().Err(err).Msg("error while sending response")
+	}
+}
+
+// SetUTMDefaultsHandle задает UTM метки текущего пользователя по умолчанию. Метки
+// добавляются ко всем урлам, которые пользователь сокращает после этого, если в запросе
+// на сокращение не заданы свои. Пустые метки сбрасывают значения по умолчанию
+func SetUTMDefaultsHandle(w http.ResponseWriter, r *http.Request) {
+	uID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var utm models.UTM
+	if err := json.NewDecoder(r.Body).Decode(&utm); err != nil {
+		logger.Log.Info().Err(err).Msg("error while deserializing json")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := service.ValidateUTM(utm); err != nil {
+		logger.Log.Info().Err(err).Send()
+		writeJSONError(w, http.StatusBadRequest, api.ErrCodeInvalidUTM, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := shortener.App.Store.SetUTMDefaults(ctx, uID, &utm); err != nil {
+		logger.Log.Error().Err(err).Msg("error while saving utm defaults")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteUTMDefaultsHandle сбрасывает UTM метки текущего пользователя по умолчанию
+func DeleteUTMDefaultsHandle(w http.ResponseWriter, r *http.Request) {
+	uID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := shortener.App.Store.SetUTMDefaults(ctx, uID, nil); err != nil {
+		logger.Log.Error().Err(err).Msg("error while deleting utm defaults")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetURLStatsHandle возвращает владельцу статистику переходов по сокращенному урлу
+func GetURLStatsHandle(w http.ResponseWriter, r *http.Request) {
+	entry, ok := getOwnURLEntry(w, r)
+	if !ok {
+		return
+	}
+	sID := entry.ShortenID
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	urlStats, err := shortener.App.Store.GetStats(ctx, sID)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while getting url stats")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if urlStats == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	resp := stats.Response{
+		ShortURL:       shortURL(sID),
+		TotalClicks:    urlStats.TotalClicks,
+		UniqueVisitors: urlStats.UniqueVisitors,
+		ByDay:          make([]stats.DayClicks, 0, len(urlStats.ByDay)),
+		ByReferrer:     make([]stats.ReferrerClicks, 0, len(urlStats.ByReferrer)),
+	}
+	for day, n := range urlStats.ByDay {
+		resp.ByDay = append(resp.ByDay, stats.DayClicks{Day: day, Clicks: n})
+	}
+	for ref, n := range urlStats.ByReferrer {
+		resp.ByReferrer = append(resp.ByReferrer, stats.ReferrerClicks{Referrer: ref, Clicks: n})
+	}
+	sort.Slice(resp.ByDay, func(i, j int) bool {
+		return resp.ByDay[i].Day < resp.ByDay[j].Day
+	})
+	sort.Slice(resp.ByReferrer, func(i, j int) bool {
+		if resp.ByReferrer[i].Clicks != resp.ByReferrer[j].Clicks {
+			return resp.ByReferrer[i].Clicks > resp.ByReferrer[j].Clicks
+		}
+		return resp.ByReferrer[i].Referrer < resp.ByReferrer[j].Referrer
+	})
+
+	respDecoded, err := json.Marshal(resp)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while serializing response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(contentType, applicationJSON)
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(respDecoded); err != nil {
+		logger.Log.Info().Err(err).Msg("error while sending response")
+	}
+}
+
+// verifyShortenID возвращает идентификатор из пути короткой ссылки. Подпись ссылки
+// проверяется до обращения к хранилищу, при неверной подписи клиенту отвечается 404,
+// как и для несуществующей ссылки
+func verifyShortenID(w http.ResponseWriter, r *http.Request) (models.ShortenID, bool) {
+	sID, err := shortener.App.Signer.Verify(chi.URLParam(r, "id"))
+	if err != nil {
+		logger.Log.Debug().Err(err).Send()
+		w.WriteHeader(http.StatusNotFound)
+		return "", false
+	}
+	return sID, true
+}
+
+// shortURL возвращает короткую ссылку на идентификатор, подписанную, если подпись включена
+func shortURL(sID models.ShortenID) string {
+	return shortener.App.Configs.BaseURL + "/" + shortener.App.Signer.Sign(sID)
+}
+
+// normalizeURL нормализует урл и при ошибке отвечает клиенту 422
+func normalizeURL(w http.ResponseWriter, raw string) (models.FullURL, bool) {
+	fURL, err := service.NormalizeURL(raw)
+	if err != nil {
+		logger.Log.Info().Err(err).Send()
+		writeJSONError(w, http.StatusUnprocessableEntity, api.ErrCodeInvalidURL, err.Error())
+		return "", false
+	}
+	return fURL, true
+}
+
+// validateAlias проверяет пользовательский alias и при ошибке отвечает клиенту 400.
+// Пустой alias допустим
+func validateAlias(w http.ResponseWriter, alias models.ShortenID) bool {
+	if alias == "" {
+		return true
+	}
+	if err := service.ValidateAlias(alias); err != nil {
+		logger.Log.Info().Err(err).Send()
+		writeJSONError(w, http.StatusBadRequest, api.ErrCodeInvalidAlias, err.Error())
+		return false
+	}
+	return true
+}
+
+// validateURLOptions проверяет срок жизни, лимит переходов и код редиректа урла
+// и при ошибке отвечает клиенту 400
+func validateURLOptions(w http.ResponseWriter, opts models.URLOptions) bool {
+	var optErr optionsError
+	if errors.As(checkURLOptions(opts, time.Now()), &optErr) {
+		writeOptionsError(w, optErr)
+		return false
+	}
+	return true
+}
+
+// optionsError ошибка параметров урла с кодом ошибки для ответа клиенту
+type optionsError struct {
+	code string
+	err  error
+}
+
+func (e optionsError) Error() string {
+	return e.err.Error()
+}
+
+// checkURLOptions проверяет параметры урла и возвращает optionsError
+func checkURLOptions(opts models.URLOptions, now time.Time) error {
+	if err := service.ValidateURLOptions(opts, now); err != nil {
+		return optionsError{code: api.ErrCodeInvalidExpiration, err: err}
+	}
+	if opts.RedirectCode != 0 {
+		if err := service.ValidateRedirectCode(opts.RedirectCode); err != nil {
+			return optionsError{code: api.ErrCodeInvalidRedirect, err: err}
+		}
+	}
+	if opts.UTM != nil {
+		if err := service.ValidateUTM(*opts.UTM); err != nil {
+			return optionsError{code: api.ErrCodeInvalidUTM, err: err}
+		}
+	}
+	return nil
+}
+
+// writeOptionsError отвечает клиенту 400 с кодом ошибки параметров урла
+func writeOptionsError(w http.ResponseWriter, err optionsError) {
+	logger.Log.Info().Err(err).Send()
+	writeJSONError(w, http.StatusBadRequest, err.code, err.Error())
+}
+
+// setOneTime делает урл одноразовым и при ошибке отвечает клиенту 400
+func setOneTime(w http.ResponseWriter, opts *models.URLOptions) bool {
+	if err := service.SetOneTime(opts); err != nil {
+		logger.Log.Info().Err(err).Send()
+		writeJSONError(w, http.StatusBadRequest, api.ErrCodeInvalidExpiration, err.Error())
+		return false
+	}
+	return true
+}
+
+// applyUTMDefaults дополняет UTM метки урла метками пользователя по умолчанию
+// и при ошибке отвечает клиенту 500
+func applyUTMDefaults(ctx context.Context, w http.ResponseWriter, uID models.UserID, opts *models.URLOptions) bool {
+	defaults, err := shortener.App.Store.GetUTMDefaults(ctx, uID)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while getting utm defaults")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	opts.UTM = opts.UTM.WithDefaults(defaults)
+	return true
+}
+
+// validateBatchItem проверяет элемент пакета, нормализует его урл и хэширует пароль,
+// не отвечая клиенту
+func validateBatchItem(rData *v2.RequestData) error {
+	fURL, err := service.NormalizeURL(string(rData.FullURL))
+	if err != nil {
+		return err
+	}
+	rData.FullURL = fURL
+
+	if rData.Alias != "" {
+		if err := service.ValidateAlias(rData.Alias); err != nil {
+			return err
+		}
+	}
+	if rData.RedirectCode != 0 {
+		if err := service.ValidateRedirectCode(rData.RedirectCode); err != nil {
+			return err
+		}
+	}
+	if rData.UTM != nil {
+		if err := service.ValidateUTM(*rData.UTM); err != nil {
+			return err
+		}
+	}
+	if err = service.ValidateURLOptions(rData.URLOptions, time.Now()); err != nil {
+		return err
+	}
+	if rData.OneTime {
+		if err = service.SetOneTime(&rData.URLOptions); err != nil {
+			return err
+		}
+	}
+	rData.PasswordHash, err = service.HashPassword(rData.Password)
+	return err
+}
+
+// checkTarget проверяет результат поиска цели перехода и при ошибке отвечает клиенту:
+// 410 для удаленного или истекшего урла, 404 для ненайденного
+func checkTarget(w http.ResponseWriter, target *models.Target, err error) bool {
+	if errors.Is(err, storage.ErrURLDeleted) || errors.Is(err, storage.ErrURLExpired) {
+		w.WriteHeader(http.StatusGone)
+		return false
+	}
+	if err != nil {
+		logger.Log.Info().Err(err).Send()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if target == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// redirectCacheControl возвращает Cache-Control для редиректа с кодом sCode. Временные
+// редиректы не кэшируются, чтобы каждый переход доходил до сервиса. Постоянные
+// кэшируются не дольше permanentRedirectMaxAge и срока жизни урла, а урлы с лимитом
+// переходов не кэшируются вовсе
+func redirectCacheControl(sCode int, opts models.URLOptions, now time.Time) string {
+	if !service.IsPermanentRedirect(sCode) || opts.MaxClicks > 0 {
+		return "no-store"
+	}
+	maxAge := permanentRedirectMaxAge
+	if opts.ExpiresAt != nil {
+		maxAge = min(maxAge, opts.ExpiresAt.Sub(now))
+	}
+	return fmt.Sprintf("public, max-age=%d", int64(maxAge.Seconds()))
+}
+
+// setURL сохраняет урл. Заблокированный урл не сохраняется и возвращает
+// blocklist.BlockedError. Урл на свою короткую ссылку не сохраняется: по политике
+// resolve возвращается URLExistsError с этой ссылкой, по политике reject —
+// service.SelfLinkError
+func setURL(ctx context.Context, fURL models.FullURL, uID models.UserID, opts models.SetOptions) (*models.ShortenID, error) {
+	if err := shortener.App.Blocklist.Check(fURL); err != nil {
+		return nil, err
+	}
+	sID, err := shortener.App.SelfLinks.Check(ctx, shortener.App.Store, fURL)
+	if err != nil {
+		return nil, err
+	}
+	if sID != "" {
+		return nil, storage.URLExistsError{OriginalURL: fURL, SID: sID}
+	}
+	return shortener.App.Store.Set(ctx, fURL, uID, opts)
+}
+
+// resolveBatchSelfLink проверяет, не ведет ли урл элемента пакета на свою короткую
+// ссылку. Если ведет, заполняет payload и возвращает true
+func resolveBatchSelfLink(ctx context.Context, fURL models.FullURL, payload *v2.ResponsePayload) bool {
+	sID, err := shortener.App.SelfLinks.Check(ctx, shortener.App.Store, fURL)
+	var selfLinkErr service.SelfLinkError
+	switch {
+	case errors.As(err, &selfLinkErr):
+		payload.Status = v2.StatusInvalid
+		payload.Error = err.Error()
+	case err != nil:
+		payload.Status = v2.StatusError
+		payload.Error = err.Error()
+	case sID != "":
+		payload.Status = v2.StatusExists
+		payload.ShortURL = shortURL(sID)
+	default:
+		return false
+	}
+	return true
+}
+
+// setBlockedPayload заполняет payload элемента пакета с заблокированным урлом
+func setBlockedPayload(payload *v2.ResponsePayload, blockedErr blocklist.BlockedError) {
+	payload.Status = v2.StatusBlocked
+	payload.Error = blockedErr.Error()
+	payload.Reason = &api.BlockReason{Kind: blockedErr.Kind, Rule: blockedErr.Rule}
+}
+
+// writeSetError отвечает клиенту ошибкой сохранения урла, не связанной с тем,
+// что урл уже сохранен
+func writeSetError(w http.ResponseWriter, err error) {
+	var aliasErr storage.AliasExistsError
+	if errors.As(err, &aliasErr) {
+		writeJSONError(w, http.StatusConflict, api.ErrCodeAliasTaken, err.Error())
+		return
+	}
+	var selfLinkErr service.SelfLinkError
+	if errors.As(err, &selfLinkErr) {
+		writeJSONError(w, http.StatusUnprocessableEntity, api.ErrCodeSelfLink, err.Error())
+		return
+	}
+	var blockedErr blocklist.BlockedError
+	if errors.As(err, &blockedErr) {
+		writeBlockedError(w, http.StatusForbidden, blockedErr)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// writeJSONError отвечает клиенту ошибкой в формате api.ErrorResponse
+func writeJSONError(w http.ResponseWriter, sCode int, code string, msg string) {
+	writeErrorResponse(w, sCode, api.ErrorResponse{
+		Error: api.ErrorPayload{
+			Code:    code,
+			Message: msg,
+		},
+	})
+}
+
+// writeBlockedError отвечает клиенту ошибкой с правилом блоклиста, под которое подпал урл
+func writeBlockedError(w http.ResponseWriter, sCode int, blockedErr blocklist.BlockedError) {
+	writeErrorResponse(w, sCode, api.ErrorResponse{
+		Error: api.ErrorPayload{
+			Code:    api.ErrCodeBlockedURL,
+			Message: blockedErr.Error(),
+			Reason:  &api.BlockReason{Kind: blockedErr.Kind, Rule: blockedErr.Rule},
+		},
+	})
+}
+
+func writeErrorResponse(w http.ResponseWriter, sCode int, resp api.ErrorResponse) {
+	respDecoded, err := json.Marshal(resp)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while serializing response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(contentType, applicationJSON)
+	w.WriteHeader(sCode)
+	if _, err = w.Write(respDecoded); err != nil {
+		logger.Log.Info().Err(err).Msg("error while sending response")
+	}
+}
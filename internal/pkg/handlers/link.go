@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/nartim88/urlshortener/internal/app/shortener"
+	"github.com/nartim88/urlshortener/internal/pkg/blocklist"
+	"github.com/nartim88/urlshortener/internal/pkg/logger"
+	"github.com/nartim88/urlshortener/internal/pkg/middleware"
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+	"github.com/nartim88/urlshortener/internal/pkg/models/api/link"
+	"github.com/nartim88/urlshortener/internal/pkg/storage"
+)
+
+// previewPage страница предпросмотра короткой ссылки. Переход по ссылке со страницы
+// идет через сам короткий урл, так что пароль, лимит и учет переходов работают как обычно
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Link preview</title>
+</head>
+<body>
+<p>Short link: <code>{{.ShortURL}}</code></p>
+{{if .FullURL}}<p>Goes to: <code>{{.FullURL}}</code></p>
+{{else if .PasswordProtected}}<p>This link is password protected, its destination is shown only to the owner.</p>
+{{end}}{{if not .CreatedAt.IsZero}}<p>Created: {{.CreatedAt.UTC.Format "2006-01-02 15:04 MST"}}</p>
+{{end}}{{if .ExpiresAt}}<p>Expires: {{.ExpiresAt.UTC.Format "2006-01-02 15:04 MST"}}</p>
+{{end}}{{if .MaxClicks}}<p>Can be opened {{.MaxClicks}} time(s) in total.</p>
+{{end}}{{if .Blocked}}<p>This link is blocked: {{.Blocked}}.</p>
+{{else if eq .Status "active"}}<p><a href="{{.ShortURL}}" rel="noreferrer">Open link</a></p>
+{{else}}<p>This link is {{.Status}} and can no longer be opened.</p>
+{{end}}</body>
+</html>
+`))
+
+// previewData данные страницы предпросмотра
+type previewData struct {
+	link.Response
+	CreatedAt time.Time
+	// Blocked правило блоклиста, под которое подпал целевой урл
+	Blocked string
+}
+
+// GetURLInfoHandle возвращает владельцу сведения о сокращенном урле без перехода по нему
+func GetURLInfoHandle(w http.ResponseWriter, r *http.Request) {
+	entry, ok := getOwnURLEntry(w, r)
+	if !ok {
+		return
+	}
+
+	respDecoded, err := json.Marshal(linkPayload(r.Context(), entry))
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while serializing response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(contentType, applicationJSON)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(respDecoded); err != nil {
+		logger.Log.Info().Err(err).Msg("error while sending response")
+	}
+}
+
+// PreviewURLHandle показывает страницу с целью короткой ссылки вместо перехода по ней.
+// Переход не засчитывается, так что одноразовая ссылка остается рабочей
+func PreviewURLHandle(w http.ResponseWriter, r *http.Request) {
+	entry, ok := getURLEntry(w, r)
+	if !ok {
+		return
+	}
+
+	data := previewData{
+		Response:  linkPayload(r.Context(), entry),
+		CreatedAt: entry.CreatedAt,
+	}
+	var blockedErr blocklist.BlockedError
+	if data.FullURL != "" && errors.As(shortener.App.Blocklist.Check(data.FullURL), &blockedErr) {
+		data.Blocked = blockedErr.Kind + " rule " + blockedErr.Rule
+	}
+
+	sCode := http.StatusOK
+	if data.Status != link.StatusActive {
+		sCode = http.StatusGone
+	}
+
+	w.Header().Set(contentType, "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.WriteHeader(sCode)
+	if err := previewPage.Execute(w, data); err != nil {
+		logger.Log.Info().Err(err).Msg("error while sending preview page")
+	}
+}
+
+// getURLEntry возвращает запись урла из пути запроса и отвечает клиенту 404, если ее нет
+func getURLEntry(w http.ResponseWriter, r *http.Request) (*models.URLEntry, bool) {
+	sID, ok := verifyShortenID(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	entry, err := shortener.App.Store.GetEntry(ctx, sID)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while getting url entry")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if entry == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+	return entry, true
+}
+
+// getOwnURLEntry возвращает запись урла текущего пользователя из пути запроса.
+// Для ненайденного урла отвечает клиенту 404, для чужого — 403
+func getOwnURLEntry(w http.ResponseWriter, r *http.Request) (*models.URLEntry, bool) {
+	entry, ok := getURLEntry(w, r)
+	if !ok {
+		return nil, false
+	}
+	uID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok || uID == "" || uID != entry.UserID {
+		writeEditError(w, storage.ErrNotOwner)
+		return nil, false
+	}
+	return entry, true
+}
+
+// linkPayload возвращает сведения об урле в виде для клиента. Целевой урл защищенной
+// паролем ссылки виден только владельцу, иначе предпросмотр позволял бы обойти пароль
+func linkPayload(ctx context.Context, entry *models.URLEntry) link.Response {
+	resp := link.Response{
+		ShortURL: shortURL(entry.ShortenID),
+		UserID:   entry.UserID,
+		Status:   link.StatusActive,
+		Clicks:   entry.Clicks,
+		Target:   targetPayload(entry.Target),
+	}
+	if !entry.CreatedAt.IsZero() {
+		resp.CreatedAt = &entry.CreatedAt
+	}
+	switch {
+	case entry.IsDeleted:
+		resp.Status = link.StatusDeleted
+	case entry.IsExpired:
+		resp.Status = link.StatusExpired
+	}
+
+	uID, ok := middleware.UserIDFromContext(ctx)
+	isOwner := ok && uID != "" && uID == entry.UserID
+	if resp.PasswordProtected && !isOwner {
+		resp.FullURL = ""
+	}
+	return resp
+}
+
+// LookupURLHandle ищет короткую ссылку, под которой урл из параметра url уже сокращен,
+// не сокращая его заново. Урл нормализуется так же, как при сокращении
+func LookupURLHandle(w http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query().Get("url")
+	if raw == "" {
+		http.Error(w, "url query parameter is required", http.StatusBadRequest)
+		return
+	}
+	fURL, ok := normalizeURL(w, raw)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	sID, err := shortener.App.Store.GetByFullURL(ctx, fURL)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while looking up url")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if sID == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	resp := link.LookupResponse{
+		ShortURL: shortURL(*sID),
+		ID:       shortener.App.Signer.Sign(*sID),
+	}
+	respDecoded, err := json.Marshal(resp)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while serializing response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(contentType, applicationJSON)
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(respDecoded); err != nil {
+		logger.Log.Info().Err(err).Msg("error while sending response")
+	}
+}
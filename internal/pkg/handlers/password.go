@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nartim88/urlshortener/internal/app/shortener"
+	"github.com/nartim88/urlshortener/internal/pkg/logger"
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+	"github.com/nartim88/urlshortener/internal/pkg/models/api"
+	"github.com/nartim88/urlshortener/internal/pkg/service"
+)
+
+const (
+	// linkPasswordHeader заголовок с паролем защищенного урла для API клиентов
+	linkPasswordHeader = "X-Link-Password"
+	// passwordFormField поле формы ввода пароля
+	passwordFormField = "password"
+)
+
+// passwordForm форма ввода пароля защищенного урла. Форма отправляется на тот же
+// адрес, так что суффикс пути и параметры запроса сохраняются
+var passwordForm = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Password required</title>
+</head>
+<body>
+<form method="post" action="">
+{{if .Wrong}}<p>Wrong password, try again.</p>
+{{end}}<label>Password <input type="password" name="` + passwordFormField + `" autofocus required></label>
+<button type="submit">Open link</button>
+</form>
+</body>
+</html>
+`))
+
+// verifyLinkPassword проверяет пароль защищенного урла из заголовка X-Link-Password
+// или из формы. Если пароля нет или он неверный, отвечает клиенту 401: API клиенту
+// текстом, браузеру — формой ввода пароля. Каждая проверка пароля резервирует попытку
+// заранее, и если попыток к урлу слишком много, отвечает 429, не проверяя пароль
+func verifyLinkPassword(w http.ResponseWriter, r *http.Request, sID models.ShortenID, hash string) bool {
+	w.Header().Set("Cache-Control", "no-store")
+
+	password, fromHeader := r.Header.Get(linkPasswordHeader), true
+	if password == "" && r.Method == http.MethodPost {
+		password, fromHeader = r.PostFormValue(passwordFormField), false
+	}
+	if password == "" {
+		writePasswordForm(w, false)
+		return false
+	}
+
+	now := time.Now()
+	if ok, retryAfter := shortener.App.PasswordAttempts.Allow(sID, now); !ok {
+		w.Header().Set("Retry-After", strconv.FormatInt(int64((retryAfter+time.Second-1)/time.Second), 10))
+		http.Error(w, "too many password attempts", http.StatusTooManyRequests)
+		return false
+	}
+	if service.CheckPassword(hash, password) {
+		shortener.App.PasswordAttempts.Release(sID, now)
+		return true
+	}
+
+	if fromHeader {
+		http.Error(w, "wrong link password", http.StatusUnauthorized)
+		return false
+	}
+	writePasswordForm(w, true)
+	return false
+}
+
+// writePasswordForm отвечает клиенту 401 с формой ввода пароля
+func writePasswordForm(w http.ResponseWriter, wrong bool) {
+	w.Header().Set(contentType, "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	if err := passwordForm.Execute(w, struct{ Wrong bool }{wrong}); err != nil {
+		logger.Log.Info().Err(err).Msg("error while sending password form")
+	}
+}
+
+// hashLinkPassword заменяет хэш пароля в параметрах урла хэшем пароля из запроса,
+// чтобы клиент не мог передать хэш напрямую, и при ошибке отвечает клиенту 400
+func hashLinkPassword(w http.ResponseWriter, password string, opts *models.URLOptions) bool {
+	hash, err := service.HashPassword(password)
+	if err != nil {
+		logger.Log.Info().Err(err).Send()
+		writeJSONError(w, http.StatusBadRequest, api.ErrCodeInvalidPassword, err.Error())
+		return false
+	}
+	opts.PasswordHash = hash
+	return true
+}
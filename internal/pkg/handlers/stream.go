@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nartim88/urlshortener/internal/app/shortener"
+	"github.com/nartim88/urlshortener/internal/pkg/blocklist"
+	"github.com/nartim88/urlshortener/internal/pkg/logger"
+	"github.com/nartim88/urlshortener/internal/pkg/middleware"
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+	v2 "github.com/nartim88/urlshortener/internal/pkg/models/api/v2"
+	"github.com/nartim88/urlshortener/internal/pkg/service"
+	"github.com/nartim88/urlshortener/internal/pkg/storage"
+)
+
+const (
+	applicationNDJSON = "application/x-ndjson"
+	// streamWorkers количество строк потока, одновременно сохраняемых в хранилище
+	streamWorkers = 8
+	// maxStreamLineSize максимальная длина строки потока
+	maxStreamLineSize = 64 * 1024
+)
+
+// streamLine строка входящего потока или ошибка его чтения
+type streamLine struct {
+	data []byte
+	err  error
+}
+
+// GetStreamShortURLsHandle сокращает урлы из потока NDJSON. Строки читаются по одной
+// и сохраняются не более чем streamWorkers одновременно, результат по каждой строке
+// отправляется клиенту сразу, как только готов, поэтому порядок ответов может
+// не совпадать с порядком строк
+func GetStreamShortURLsHandle(w http.ResponseWriter, r *http.Request) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get(contentType)); mediaType != applicationNDJSON {
+		http.Error(w, "content type must be "+applicationNDJSON, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// ответ начинает отправляться до того, как тело запроса прочитано целиком
+	if err := rc.EnableFullDuplex(); err != nil {
+		logger.Log.Debug().Err(err).Msg("full duplex is not supported")
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	uID, _ := middleware.UserIDFromContext(r.Context())
+
+	utmDefaults, err := shortener.App.Store.GetUTMDefaults(ctx, uID)
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while getting utm defaults")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	lines := make(chan streamLine, streamWorkers)
+	results := make(chan v2.ResponsePayload, streamWorkers)
+
+	go readStream(ctx, r, lines)
+
+	var wg sync.WaitGroup
+	for i := 0; i < streamWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for line := range lines {
+				select {
+				case results <- shortenStreamLine(ctx, uID, utmDefaults, line):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	w.Header().Set(contentType, applicationNDJSON)
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	for res := range results {
+		if ctx.Err() != nil {
+			continue
+		}
+		err := enc.Encode(res)
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			logger.Log.Info().Err(err).Msg("error while streaming response, stopping")
+			cancel()
+		}
+	}
+}
+
+// readStream читает непустые строки тела запроса в lines, пока поток не закончится
+// или ctx не будет отменен
+func readStream(ctx context.Context, r *http.Request, lines chan<- streamLine) {
+	defer close(lines)
+
+	send := func(line streamLine) bool {
+		select {
+		case lines <- line:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 4096), maxStreamLineSize)
+	for scanner.Scan() {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if !send(streamLine{data: bytes.Clone(data)}) {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		send(streamLine{err: err})
+	}
+}
+
+// shortenStreamLine сохраняет урл из строки потока и возвращает результат для клиента.
+// utmDefaults дополняют UTM метки урла
+func shortenStreamLine(ctx context.Context, uID models.UserID, utmDefaults *models.UTM, line streamLine) v2.ResponsePayload {
+	if line.err != nil {
+		return v2.ResponsePayload{Status: v2.StatusError, Error: line.err.Error()}
+	}
+
+	var rData v2.RequestData
+	if err := json.Unmarshal(line.data, &rData); err != nil {
+		return v2.ResponsePayload{Status: v2.StatusInvalid, Error: err.Error()}
+	}
+
+	payload := v2.ResponsePayload{CorrelationID: rData.CorrelationID}
+	if err := validateBatchItem(&rData); err != nil {
+		payload.Status = v2.StatusInvalid
+		payload.Error = err.Error()
+		return payload
+	}
+
+	rData.UTM = rData.UTM.WithDefaults(utmDefaults)
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	sID, err := setURL(ctx, rData.FullURL, uID, models.SetOptions{
+		Alias:      rData.Alias,
+		URLOptions: rData.URLOptions,
+	})
+
+	var existsErr storage.URLExistsError
+	var aliasErr storage.AliasExistsError
+	var selfLinkErr service.SelfLinkError
+	var blockedErr blocklist.BlockedError
+	switch {
+	case errors.As(err, &existsErr):
+		payload.Status = v2.StatusExists
+		sID = &existsErr.SID
+	case errors.As(err, &blockedErr):
+		setBlockedPayload(&payload, blockedErr)
+		return payload
+	case errors.As(err, &aliasErr), errors.As(err, &selfLinkErr):
+		payload.Status = v2.StatusInvalid
+		payload.Error = err.Error()
+		return payload
+	case err != nil:
+		payload.Status = v2.StatusError
+		payload.Error = err.Error()
+		return payload
+	default:
+		payload.Status = v2.StatusCreated
+	}
+	payload.ShortURL = shortURL(*sID)
+	return payload
+}
@@ -0,0 +1,67 @@
+package janitor
+
+import (
+	"context"
+	"time"
+
+	"github.com/nartim88/urlshortener/internal/pkg/logger"
+	"github.com/nartim88/urlshortener/internal/pkg/storage"
+)
+
+const purgeTimeout = 30 * time.Second
+
+// Janitor в фоне периодически удаляет из хранилища урлы с истекшим сроком жизни
+// или исчерпанным лимитом переходов
+type Janitor struct {
+	store    storage.Storage
+	interval time.Duration
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+// New инициализирует Janitor для хранилища store с периодом очистки interval
+func New(store storage.Storage, interval time.Duration) *Janitor {
+	return &Janitor{
+		store:    store,
+		interval: interval,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Run очищает хранилище раз в interval, пока не будет вызван Close
+func (j *Janitor) Run() {
+	defer close(j.done)
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			j.purge()
+		case <-j.quit:
+			return
+		}
+	}
+}
+
+// Close останавливает Run, дождавшись окончания текущей очистки
+func (j *Janitor) Close() {
+	close(j.quit)
+	<-j.done
+}
+
+func (j *Janitor) purge() {
+	ctx, cancel := context.WithTimeout(context.Background(), purgeTimeout)
+	defer cancel()
+
+	n, err := j.store.PurgeExpired(ctx, time.Now())
+	if err != nil {
+		logger.Log.Error().Err(err).Msg("error while purging expired urls")
+		return
+	}
+	if n > 0 {
+		logger.Log.Info().Int("count", n).Msg("expired urls are purged")
+	}
+}
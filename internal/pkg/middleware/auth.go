@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+
+	"github.com/nartim88/urlshortener/internal/app/shortener"
+	"github.com/nartim88/urlshortener/internal/pkg/logger"
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+const (
+	// AuthCookieName имя cookie с подписанным идентификатором пользователя
+	AuthCookieName = "token"
+	tokenTTL       = 30 * 24 * time.Hour
+)
+
+type ctxKey int
+
+const (
+	userIDKey ctxKey = iota
+	// invalidTokenKey признак того, что cookie пользователя не прошла проверку
+	invalidTokenKey
+)
+
+// claims содержимое JWT токена
+type claims struct {
+	jwt.RegisteredClaims
+	UserID models.UserID `json:"user_id"`
+}
+
+// WithAuth достает идентификатор пользователя из подписанной cookie и кладет его в контекст
+// запроса. Если cookie отсутствует или ее подпись не прошла проверку, например после смены
+// ключа, генерирует нового пользователя и выставляет ему cookie. Неверная cookie отмечается
+// в контексте, чтобы эндпоинты с данными пользователя могли ответить 401 через RequireAuth
+func WithAuth(next http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		var uID models.UserID
+		var invalid bool
+
+		if cookie, err := r.Cookie(AuthCookieName); err == nil {
+			if uID, err = parseToken(cookie.Value); err != nil {
+				logger.Log.Info().Err(err).Msg("invalid auth token")
+				invalid = true
+			}
+		}
+
+		if uID == "" {
+			uID = models.UserID(uuid.NewString())
+			token, err := buildToken(uID)
+			if err != nil {
+				logger.Log.Error().Stack().Err(err).Msg("error while building auth token")
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     AuthCookieName,
+				Value:    token,
+				Path:     "/",
+				MaxAge:   int(tokenTTL.Seconds()),
+				HttpOnly: true,
+			})
+		}
+
+		ctx := context.WithValue(r.Context(), userIDKey, uID)
+		if invalid {
+			ctx = context.WithValue(ctx, invalidTokenKey, true)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(f)
+}
+
+// RequireAuth отвечает 401, если WithAuth не принял cookie пользователя. Новый пользователь,
+// выданный вместо неверной cookie, не должен молча получать пустые данные
+func RequireAuth(next http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		if invalid, _ := r.Context().Value(invalidTokenKey).(bool); invalid {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(f)
+}
+
+// UserIDFromContext возвращает идентификатор пользователя, положенный в контекст WithAuth
+func UserIDFromContext(ctx context.Context) (models.UserID, bool) {
+	uID, ok := ctx.Value(userIDKey).(models.UserID)
+	return uID, ok
+}
+
+// buildToken создает подписанный JWT токен с идентификатором пользователя
+func buildToken(uID models.UserID) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenTTL)),
+		},
+		UserID: uID,
+	})
+	return token.SignedString([]byte(shortener.App.Configs.SecretKey))
+}
+
+// parseToken проверяет подпись токена и возвращает идентификатор пользователя
+func parseToken(tokenString string) (models.UserID, error) {
+	c := &claims{}
+	token, err := jwt.ParseWithClaims(tokenString, c, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return []byte(shortener.App.Configs.SecretKey), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if !token.Valid || c.UserID == "" {
+		return "", errors.New("auth token is not valid")
+	}
+	return c.UserID, nil
+}
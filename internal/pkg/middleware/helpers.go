@@ -29,11 +29,19 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode
 }
 
+// Unwrap позволяет http.ResponseController добраться до исходного http.ResponseWriter
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 // compressWriter реализует интерфейс http.ResponseWriter,
 // сжимает передаваемые данные и выставляет правильные HTTP-заголовки
 type compressWriter struct {
-	w  http.ResponseWriter
-	zw *gzip.Writer
+	w           http.ResponseWriter
+	zw          *gzip.Writer
+	wroteHeader bool
+	// compress тело ответа сжимается. Решение принимается по статусу ответа
+	compress bool
 }
 
 func newCompressWriter(w http.ResponseWriter) *compressWriter {
@@ -47,17 +55,56 @@ func (c *compressWriter) Header() http.Header {
 }
 
 func (c *compressWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.compress {
+		return c.w.Write(p)
+	}
 	return c.zw.Write(p)
 }
 
+// WriteHeader выставляет Content-Encoding и сжимает тело, в том числе у ответов
+// с ошибками. Ответы без тела (1xx, 204, 304) и редиректы не сжимаются: gzip поток
+// превратил бы пустое тело в непустое
 func (c *compressWriter) WriteHeader(statusCode int) {
-	if statusCode < 300 {
+	if statusCode >= 100 && statusCode < 200 {
+		c.w.WriteHeader(statusCode)
+		return
+	}
+	c.wroteHeader = true
+	c.compress = statusCode != http.StatusNoContent && (statusCode < 300 || statusCode >= 400)
+	if c.compress {
 		c.w.Header().Set("Content-Encoding", "gzip")
+		c.w.Header().Del("Content-Length")
 	}
 	c.w.WriteHeader(statusCode)
 }
 
+// FlushError отправляет клиенту накопленные в gzip.Writer данные, чтобы потоковые
+// ответы не задерживались до закрытия writer
+func (c *compressWriter) FlushError() error {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.compress {
+		if err := c.zw.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(c.w).Flush()
+}
+
+// Unwrap позволяет http.ResponseController добраться до исходного http.ResponseWriter
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.w
+}
+
+// Close дописывает конец gzip потока, если тело ответа сжималось
 func (c *compressWriter) Close() error {
+	if !c.compress {
+		return nil
+	}
 	return c.zw.Close()
 }
 
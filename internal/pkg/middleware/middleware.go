@@ -10,6 +10,7 @@ import (
 var All = []func(http.Handler) http.Handler{
 	WithLogging,
 	GZipMiddleware,
+	WithAuth,
 }
 
 func WithLogging(next http.Handler) http.Handler {
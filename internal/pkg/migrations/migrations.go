@@ -0,0 +1,266 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/nartim88/urlshortener/internal/pkg/logger"
+)
+
+// advisoryLockID ключ advisory lock, не дающий нескольким репликам
+// применять миграции одновременно
+const advisoryLockID int64 = 7_243_580_813
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// Migration версия схемы бд с sql для ее применения и отката
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status состояние миграции в бд
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Migrator применяет и откатывает встроенные в бинарник миграции
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+}
+
+// New инициализирует Migrator с миграциями из директории sql
+func New(pool *pgxpool.Pool) (*Migrator, error) {
+	migrations, err := load(files)
+	if err != nil {
+		return nil, fmt.Errorf("error while loading migrations: %w", err)
+	}
+	return &Migrator{pool, migrations}, nil
+}
+
+// Up применяет все еще не примененные миграции
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mg := range m.migrations {
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+			err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, mg.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, `
+					INSERT INTO schema_migrations (version, name)
+					VALUES ($1, $2)`,
+					mg.Version, mg.Name,
+				)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error while applying migration %d_%s: %w", mg.Version, mg.Name, err)
+			}
+			logger.Log.Info().Int64("version", mg.Version).Str("name", mg.Name).Msg("migration is applied")
+		}
+		return nil
+	})
+}
+
+// Down откатывает последнюю примененную миграцию
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mg := m.migrations[i]
+			if _, ok := applied[mg.Version]; !ok {
+				continue
+			}
+			err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, mg.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, `
+					DELETE FROM schema_migrations
+					WHERE version=$1`,
+					mg.Version,
+				)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error while reverting migration %d_%s: %w", mg.Version, mg.Name, err)
+			}
+			logger.Log.Info().Int64("version", mg.Version).Str("name", mg.Name).Msg("migration is reverted")
+			return nil
+		}
+
+		logger.Log.Info().Msg("no migrations to revert")
+		return nil
+	})
+}
+
+// Status возвращает состояние всех известных миграций
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mg := range m.migrations {
+			st := Status{Version: mg.Version, Name: mg.Name}
+			if appliedAt, ok := applied[mg.Version]; ok {
+				st.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, st)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// withLock выполняет f на выделенном соединении под advisory lock,
+// предварительно создав таблицу schema_migrations
+func (m *Migrator) withLock(ctx context.Context, f func(conn *pgxpool.Conn) error) (err error) {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("error while acquiring db connection: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, advisoryLockID); err != nil {
+		return fmt.Errorf("error while acquiring migrations lock: %w", err)
+	}
+	defer func() {
+		if _, unlockErr := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, advisoryLockID); unlockErr != nil {
+			err = errors.Join(err, fmt.Errorf("error while releasing migrations lock: %w", unlockErr))
+		}
+	}()
+
+	_, err = conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+		    version BIGINT PRIMARY KEY,
+		    name TEXT NOT NULL,
+		    applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+	)
+	if err != nil {
+		return fmt.Errorf("error while creating schema_migrations table: %w", err)
+	}
+
+	return f(conn)
+}
+
+// appliedVersions возвращает примененные версии и время их применения
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("error while selecting applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// load читает миграции вида <version>_<name>.<up|down>.sql, отсортированные по версии
+func load(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "sql/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, fName := range names {
+		base := path.Base(fName)
+
+		stem, direction, ok := cutDirection(base)
+		if !ok {
+			return nil, fmt.Errorf("migration file %s must end with .up.sql or .down.sql", base)
+		}
+
+		rawVersion, name, ok := strings.Cut(stem, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration file %s must be named <version>_<name>", base)
+		}
+		version, err := strconv.ParseInt(rawVersion, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s has invalid version: %w", base, err)
+		}
+
+		data, err := fs.ReadFile(fsys, fName)
+		if err != nil {
+			return nil, err
+		}
+
+		mg, ok := byVersion[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: name}
+			byVersion[version] = mg
+		}
+		if direction == "up" {
+			mg.Up = string(data)
+		} else {
+			mg.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mg := range byVersion {
+		if mg.Up == "" || mg.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", mg.Version, mg.Name)
+		}
+		migrations = append(migrations, *mg)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// cutDirection отделяет от имени файла суффикс .up.sql или .down.sql
+func cutDirection(fName string) (stem, direction string, ok bool) {
+	if stem, ok = strings.CutSuffix(fName, ".up.sql"); ok {
+		return stem, "up", true
+	}
+	if stem, ok = strings.CutSuffix(fName, ".down.sql"); ok {
+		return stem, "down", true
+	}
+	return "", "", false
+}
@@ -0,0 +1,21 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := load(files)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, mg := range migrations {
+		assert.Equal(t, int64(i+1), mg.Version, "migration versions must be sequential")
+		assert.NotEmpty(t, mg.Name)
+		assert.NotEmpty(t, mg.Up)
+		assert.NotEmpty(t, mg.Down)
+	}
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS shortener_user_id_idx;
+ALTER TABLE shortener DROP COLUMN IF EXISTS user_id;
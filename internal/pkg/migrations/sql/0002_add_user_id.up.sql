@@ -0,0 +1,2 @@
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS user_id uuid;
+CREATE INDEX IF NOT EXISTS shortener_user_id_idx ON shortener (user_id);
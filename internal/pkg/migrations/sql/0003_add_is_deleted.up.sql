@@ -0,0 +1 @@
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS is_deleted BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,3 @@
+DROP SEQUENCE IF EXISTS shortener_short_url_seq;
+DROP INDEX IF EXISTS shortener_short_url_unique_idx;
+CREATE INDEX IF NOT EXISTS shortener_short_url_idx ON shortener (short_url);
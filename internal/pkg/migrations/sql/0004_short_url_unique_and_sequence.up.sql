@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS shortener_short_url_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS shortener_short_url_unique_idx ON shortener (short_url);
+CREATE SEQUENCE IF NOT EXISTS shortener_short_url_seq AS BIGINT START WITH 1;
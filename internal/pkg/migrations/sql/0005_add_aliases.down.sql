@@ -0,0 +1,14 @@
+-- Откат удаляет алиасы, а остальные идентификаторы должны помещаться в VARCHAR(8).
+-- Если есть более длинные идентификаторы (ID_LENGTH больше 8), откат прерывается,
+-- а не обрезает их
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM shortener WHERE NOT is_alias AND length(short_url) > 8) THEN
+        RAISE EXCEPTION 'cannot narrow short_url to VARCHAR(8): some ids are longer than 8 characters';
+    END IF;
+END $$;
+DELETE FROM shortener WHERE is_alias;
+DROP INDEX IF EXISTS shortener_full_url_unique_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS shortener_full_url_unique_idx ON shortener (full_url);
+ALTER TABLE shortener DROP COLUMN IF EXISTS is_alias;
+ALTER TABLE shortener ALTER COLUMN short_url TYPE VARCHAR(8);
@@ -0,0 +1,4 @@
+ALTER TABLE shortener ALTER COLUMN short_url TYPE VARCHAR(64);
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS is_alias BOOLEAN NOT NULL DEFAULT FALSE;
+DROP INDEX IF EXISTS shortener_full_url_unique_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS shortener_full_url_unique_idx ON shortener (full_url) WHERE NOT is_alias;
@@ -0,0 +1,7 @@
+DELETE FROM shortener WHERE NOT is_alias AND (expires_at IS NOT NULL OR max_clicks IS NOT NULL);
+DROP INDEX IF EXISTS shortener_expires_at_idx;
+DROP INDEX IF EXISTS shortener_full_url_unique_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS shortener_full_url_unique_idx ON shortener (full_url) WHERE NOT is_alias;
+ALTER TABLE shortener DROP COLUMN IF EXISTS clicks;
+ALTER TABLE shortener DROP COLUMN IF EXISTS max_clicks;
+ALTER TABLE shortener DROP COLUMN IF EXISTS expires_at;
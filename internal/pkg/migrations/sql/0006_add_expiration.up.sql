@@ -0,0 +1,7 @@
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS max_clicks BIGINT;
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS clicks BIGINT NOT NULL DEFAULT 0;
+DROP INDEX IF EXISTS shortener_full_url_unique_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS shortener_full_url_unique_idx ON shortener (full_url)
+    WHERE NOT is_alias AND expires_at IS NULL AND max_clicks IS NULL;
+CREATE INDEX IF NOT EXISTS shortener_expires_at_idx ON shortener (expires_at) WHERE expires_at IS NOT NULL;
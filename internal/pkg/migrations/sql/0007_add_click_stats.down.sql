@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS shortener_visitors;
+DROP TABLE IF EXISTS shortener_clicks_referrers;
+DROP TABLE IF EXISTS shortener_clicks_daily;
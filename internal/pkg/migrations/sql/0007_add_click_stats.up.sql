@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS shortener_clicks_daily (
+    short_url VARCHAR(64) NOT NULL REFERENCES shortener (short_url) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (short_url, day)
+);
+CREATE TABLE IF NOT EXISTS shortener_clicks_referrers (
+    short_url VARCHAR(64) NOT NULL REFERENCES shortener (short_url) ON DELETE CASCADE,
+    referrer TEXT NOT NULL,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (short_url, referrer)
+);
+CREATE TABLE IF NOT EXISTS shortener_visitors (
+    short_url VARCHAR(64) NOT NULL REFERENCES shortener (short_url) ON DELETE CASCADE,
+    ip_hash VARCHAR(64) NOT NULL,
+    PRIMARY KEY (short_url, ip_hash)
+);
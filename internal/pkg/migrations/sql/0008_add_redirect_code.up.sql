@@ -0,0 +1,4 @@
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS redirect_code SMALLINT;
+DROP INDEX IF EXISTS shortener_full_url_unique_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS shortener_full_url_unique_idx ON shortener (full_url)
+    WHERE NOT is_alias AND expires_at IS NULL AND max_clicks IS NULL AND redirect_code IS NULL;
@@ -0,0 +1,9 @@
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS utm JSONB;
+DROP INDEX IF EXISTS shortener_full_url_unique_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS shortener_full_url_unique_idx ON shortener (full_url)
+    WHERE NOT is_alias AND expires_at IS NULL AND max_clicks IS NULL AND redirect_code IS NULL
+        AND NOT passthrough AND utm IS NULL;
+CREATE TABLE IF NOT EXISTS shortener_utm_defaults (
+    user_id UUID PRIMARY KEY,
+    utm JSONB NOT NULL
+);
@@ -0,0 +1,8 @@
+DELETE FROM shortener
+    WHERE NOT is_alias AND expires_at IS NULL AND max_clicks IS NULL AND redirect_code IS NULL
+        AND NOT passthrough AND utm IS NULL AND password_hash IS NOT NULL;
+DROP INDEX IF EXISTS shortener_full_url_unique_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS shortener_full_url_unique_idx ON shortener (full_url)
+    WHERE NOT is_alias AND expires_at IS NULL AND max_clicks IS NULL AND redirect_code IS NULL
+        AND NOT passthrough AND utm IS NULL;
+ALTER TABLE shortener DROP COLUMN IF EXISTS password_hash;
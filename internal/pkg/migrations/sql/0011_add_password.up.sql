@@ -0,0 +1,5 @@
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS password_hash TEXT;
+DROP INDEX IF EXISTS shortener_full_url_unique_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS shortener_full_url_unique_idx ON shortener (full_url)
+    WHERE NOT is_alias AND expires_at IS NULL AND max_clicks IS NULL AND redirect_code IS NULL
+        AND NOT passthrough AND utm IS NULL AND password_hash IS NULL;
@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS shortener_history;
+DELETE FROM shortener AS edited
+    USING shortener AS other
+    WHERE edited.is_edited AND edited.full_url = other.full_url AND edited.id <> other.id
+        AND (NOT other.is_edited OR other.created_at < edited.created_at)
+        AND NOT edited.is_alias AND edited.expires_at IS NULL AND edited.max_clicks IS NULL
+        AND edited.redirect_code IS NULL AND NOT edited.passthrough AND edited.utm IS NULL
+        AND edited.password_hash IS NULL
+        AND NOT other.is_alias AND other.expires_at IS NULL AND other.max_clicks IS NULL
+        AND other.redirect_code IS NULL AND NOT other.passthrough AND other.utm IS NULL
+        AND other.password_hash IS NULL;
+DROP INDEX IF EXISTS shortener_full_url_unique_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS shortener_full_url_unique_idx ON shortener (full_url)
+    WHERE NOT is_alias AND expires_at IS NULL AND max_clicks IS NULL AND redirect_code IS NULL
+        AND NOT passthrough AND utm IS NULL AND password_hash IS NULL;
+ALTER TABLE shortener DROP COLUMN IF EXISTS is_edited;
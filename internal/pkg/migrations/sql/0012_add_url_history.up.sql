@@ -0,0 +1,14 @@
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS is_edited BOOLEAN NOT NULL DEFAULT FALSE;
+DROP INDEX IF EXISTS shortener_full_url_unique_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS shortener_full_url_unique_idx ON shortener (full_url)
+    WHERE NOT is_alias AND NOT is_edited AND expires_at IS NULL AND max_clicks IS NULL AND redirect_code IS NULL
+        AND NOT passthrough AND utm IS NULL AND password_hash IS NULL;
+CREATE TABLE IF NOT EXISTS shortener_history (
+    id BIGSERIAL PRIMARY KEY,
+    short_url VARCHAR(64) NOT NULL REFERENCES shortener (short_url) ON DELETE CASCADE,
+    user_id uuid NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL,
+    old_target JSONB NOT NULL,
+    new_target JSONB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS shortener_history_short_url_idx ON shortener_history (short_url, id);
@@ -0,0 +1,14 @@
+DELETE FROM shortener AS deleted
+    USING shortener AS other
+    WHERE deleted.is_deleted AND deleted.full_url = other.full_url AND deleted.id <> other.id
+        AND (NOT other.is_deleted OR other.created_at < deleted.created_at)
+        AND NOT deleted.is_alias AND NOT deleted.is_edited AND deleted.expires_at IS NULL
+        AND deleted.max_clicks IS NULL AND deleted.redirect_code IS NULL AND NOT deleted.passthrough
+        AND deleted.utm IS NULL AND deleted.password_hash IS NULL
+        AND NOT other.is_alias AND NOT other.is_edited AND other.expires_at IS NULL
+        AND other.max_clicks IS NULL AND other.redirect_code IS NULL AND NOT other.passthrough
+        AND other.utm IS NULL AND other.password_hash IS NULL;
+DROP INDEX IF EXISTS shortener_full_url_unique_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS shortener_full_url_unique_idx ON shortener (full_url)
+    WHERE NOT is_alias AND NOT is_edited AND expires_at IS NULL AND max_clicks IS NULL AND redirect_code IS NULL
+        AND NOT passthrough AND utm IS NULL AND password_hash IS NULL;
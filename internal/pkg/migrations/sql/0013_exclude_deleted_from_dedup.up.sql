@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS shortener_full_url_unique_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS shortener_full_url_unique_idx ON shortener (full_url)
+    WHERE NOT is_alias AND NOT is_edited AND NOT is_deleted AND expires_at IS NULL AND max_clicks IS NULL
+        AND redirect_code IS NULL AND NOT passthrough AND utm IS NULL AND password_hash IS NULL;
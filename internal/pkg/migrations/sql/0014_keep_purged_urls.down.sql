@@ -0,0 +1,2 @@
+DELETE FROM shortener WHERE is_purged;
+ALTER TABLE shortener DROP COLUMN IF EXISTS is_purged;
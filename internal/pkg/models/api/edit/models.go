@@ -0,0 +1,75 @@
+package edit
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+// Request изменение сокращенного урла. Поля, которых нет в запросе, не меняются,
+// null или нулевое значение параметра сбрасывает его
+type Request struct {
+	FullURL Optional[models.FullURL] `json:"url"`
+	// Password новый пароль на переход, пустая строка или null снимает пароль
+	Password Optional[string] `json:"password"`
+	// OneTime true делает урл одноразовым
+	OneTime      Optional[bool]       `json:"one_time"`
+	ExpiresAt    Optional[time.Time]  `json:"expires_at"`
+	MaxClicks    Optional[int64]      `json:"max_clicks"`
+	RedirectCode Optional[int]        `json:"redirect_code"`
+	Passthrough  Optional[bool]       `json:"passthrough"`
+	UTM          Optional[models.UTM] `json:"utm"`
+}
+
+// Optional поле запроса на изменение, которое отличает отсутствующее поле от null
+type Optional[T any] struct {
+	// Set поле есть в запросе
+	Set bool
+	// Value значение поля, nil для null
+	Value *T
+}
+
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	o.Value = nil
+	if string(data) == "null" {
+		return nil
+	}
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	o.Value = &v
+	return nil
+}
+
+// Get возвращает значение поля или нулевое значение для null и отсутствующего поля
+func (o Optional[T]) Get() T {
+	if o.Value == nil {
+		var zero T
+		return zero
+	}
+	return *o.Value
+}
+
+// Change изменение урла, как оно отдается клиенту
+type Change struct {
+	UserID    models.UserID `json:"user_id"`
+	ChangedAt time.Time     `json:"changed_at"`
+	Old       Target        `json:"old"`
+	New       Target        `json:"new"`
+}
+
+// Target цель урла в одной из версий. Хэш пароля не отдается
+type Target struct {
+	// FullURL целевой урл, пустой, если он скрыт от клиента
+	FullURL      models.FullURL `json:"url,omitempty"`
+	ExpiresAt    *time.Time     `json:"expires_at,omitempty"`
+	MaxClicks    int64          `json:"max_clicks,omitempty"`
+	RedirectCode int            `json:"redirect_code,omitempty"`
+	Passthrough  bool           `json:"passthrough,omitempty"`
+	UTM          *models.UTM    `json:"utm,omitempty"`
+	// PasswordProtected для перехода нужен пароль
+	PasswordProtected bool `json:"password_protected,omitempty"`
+}
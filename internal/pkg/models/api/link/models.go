@@ -0,0 +1,33 @@
+package link
+
+import (
+	"time"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+	"github.com/nartim88/urlshortener/internal/pkg/models/api/edit"
+)
+
+const (
+	StatusActive  = "active"
+	StatusExpired = "expired"
+	StatusDeleted = "deleted"
+)
+
+// Response сведения о сокращенном урле, доступные без перехода по нему
+type Response struct {
+	ShortURL  string        `json:"short_url"`
+	UserID    models.UserID `json:"user_id,omitempty"`
+	CreatedAt *time.Time    `json:"created_at,omitempty"`
+	// Status состояние урла: active, expired или deleted
+	Status string `json:"status"`
+	// Clicks число переходов по урлу из статистики
+	Clicks int64 `json:"clicks"`
+	edit.Target
+}
+
+// LookupResponse короткая ссылка, под которой урл уже сокращен
+type LookupResponse struct {
+	ShortURL string `json:"short_url"`
+	// ID идентификатор ссылки в том виде, в каком он стоит в ее пути
+	ID string `json:"id"`
+}
@@ -0,0 +1,33 @@
+package api
+
+// Коды ошибок в ErrorPayload
+const (
+	ErrCodeInvalidURL        = "invalid_url"
+	ErrCodeInvalidAlias      = "invalid_alias"
+	ErrCodeAliasTaken        = "alias_taken"
+	ErrCodeInvalidExpiration = "invalid_expiration"
+	ErrCodeSelfLink          = "self_link"
+	ErrCodeBlockedURL        = "blocked_url"
+	ErrCodeInvalidRedirect   = "invalid_redirect_code"
+	ErrCodeInvalidUTM        = "invalid_utm"
+	ErrCodeInvalidPassword   = "invalid_password"
+	ErrCodeNotModified       = "not_modified"
+)
+
+// ErrorResponse тело ответа с описанием ошибки
+type ErrorResponse struct {
+	Error ErrorPayload `json:"error"`
+}
+
+type ErrorPayload struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Reason  *BlockReason `json:"reason,omitempty"`
+}
+
+// BlockReason правило блоклиста, под которое подпал урл
+type BlockReason struct {
+	// Kind вид правила: domain или pattern
+	Kind string `json:"kind"`
+	Rule string `json:"rule"`
+}
@@ -0,0 +1,19 @@
+package stats
+
+type Response struct {
+	ShortURL       string           `json:"short_url"`
+	TotalClicks    int64            `json:"total_clicks"`
+	UniqueVisitors int64            `json:"unique_visitors"`
+	ByDay          []DayClicks      `json:"by_day"`
+	ByReferrer     []ReferrerClicks `json:"by_referrer"`
+}
+
+type DayClicks struct {
+	Day    string `json:"day"`
+	Clicks int64  `json:"clicks"`
+}
+
+type ReferrerClicks struct {
+	Referrer string `json:"referrer"`
+	Clicks   int64  `json:"clicks"`
+}
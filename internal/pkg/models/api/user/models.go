@@ -0,0 +1,16 @@
+package user
+
+import "github.com/nartim88/urlshortener/internal/pkg/models"
+
+type URLsResponse struct {
+	Response []URLsResponsePayload
+}
+
+type URLsResponsePayload struct {
+	ShortURL string         `json:"short_url"`
+	FullURL  models.FullURL `json:"original_url"`
+}
+
+type DeleteRequest struct {
+	Data []models.ShortenID
+}
@@ -3,7 +3,13 @@ package v1
 import "github.com/nartim88/urlshortener/internal/pkg/models"
 
 type Request struct {
-	FullURL models.FullURL `json:"url"`
+	FullURL models.FullURL   `json:"url"`
+	Alias   models.ShortenID `json:"alias,omitempty"`
+	// Password пароль на переход по урлу, хранится только его хэш
+	Password string `json:"password,omitempty"`
+	// OneTime урл открывается только один раз
+	OneTime bool `json:"one_time,omitempty"`
+	models.URLOptions
 }
 
 type Response struct {
@@ -1,6 +1,9 @@
 package v2
 
-import "github.com/nartim88/urlshortener/internal/pkg/models"
+import (
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+	"github.com/nartim88/urlshortener/internal/pkg/models/api"
+)
 
 type Request struct {
 	Data []RequestData
@@ -9,13 +12,34 @@ type Request struct {
 type RequestData struct {
 	CorrelationID models.CorrelationID `json:"correlation_id"`
 	FullURL       models.FullURL       `json:"original_url"`
+	Alias         models.ShortenID     `json:"alias,omitempty"`
+	Password      string               `json:"password,omitempty"`
+	OneTime       bool                 `json:"one_time,omitempty"`
+	models.URLOptions
 }
 
 type Response struct {
 	Response []ResponsePayload
 }
 
+// Статусы обработки элемента пакета
+const (
+	// StatusCreated урл сохранен
+	StatusCreated = "created"
+	// StatusExists урл был сохранен ранее, ShortURL указывает на него
+	StatusExists = "exists"
+	// StatusInvalid элемент не прошел проверку, повторять его без изменений бессмысленно
+	StatusInvalid = "invalid"
+	// StatusBlocked урл подпадает под правило блоклиста, Reason описывает правило
+	StatusBlocked = "blocked"
+	// StatusError урл не сохранен из-за ошибки сервиса, элемент можно повторить
+	StatusError = "error"
+)
+
 type ResponsePayload struct {
 	CorrelationID models.CorrelationID `json:"correlation_id"`
-	ShortURL      string               `json:"short_url"`
+	ShortURL      string               `json:"short_url,omitempty"`
+	Status        string               `json:"status"`
+	Error         string               `json:"error,omitempty"`
+	Reason        *api.BlockReason     `json:"reason,omitempty"`
 }
@@ -1,6 +1,10 @@
 package models
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type (
 	// FullURL исходный url, переданный для сокращения
@@ -9,6 +13,8 @@ type (
 	ShortenID string
 	// CorrelationID строковый идентификатор для отслеживания запроса
 	CorrelationID string
+	// UserID идентификатор пользователя, создавшего сокращенный урл
+	UserID string
 )
 
 // FileJSONEntry структура для записи данных в файл в json формате
@@ -16,4 +22,227 @@ type FileJSONEntry struct {
 	ID        *uuid.UUID `json:"id"`
 	ShortenID ShortenID  `json:"shorten_id"`
 	FullURL   FullURL    `json:"full_url"`
+	UserID    UserID     `json:"user_id,omitempty"`
+	// IsAlias короткий идентификатор задан пользователем, запись не участвует в дедупликации
+	IsAlias bool `json:"is_alias,omitempty"`
+	// IsDeleted признак записи-надгробия, помечающей урл удаленным
+	IsDeleted bool `json:"is_deleted,omitempty"`
+	// IsHit признак записи о переходе по урлу с ограниченным числом переходов
+	IsHit bool `json:"is_hit,omitempty"`
+	// IsPurged признак записи об очистке истекшего урла: от урла остается надгробие без
+	// цели, статистики и истории. Короткий идентификатор остается занят
+	IsPurged bool `json:"is_purged,omitempty"`
+	// CreatedAt момент сохранения урла. В записях, сделанных до его учета, не задан
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	URLOptions
+	// Click событие перехода для статистики. Запись с Click не меняет сам урл.
+	// Новые записи вместо отдельных событий содержат Stats
+	Click *ClickEvent `json:"click,omitempty"`
+	// Stats прирост агрегатов статистики урла за пакет переходов. Запись со Stats не меняет сам урл
+	Stats *ClickStatsDelta `json:"stats,omitempty"`
+	// UTMDefaults метки по умолчанию пользователя UserID. Запись с UTMDefaults не
+	// относится к урлу, пустые метки сбрасывают значения по умолчанию
+	UTMDefaults *UTM `json:"utm_defaults,omitempty"`
+	// Change изменение урла владельцем. Запись с Change заменяет целевой урл и параметры
+	Change *URLChange `json:"change,omitempty"`
+	// Clicks число переходов, восстановленное по записям IsHit. В файл не пишется
+	Clicks int64 `json:"-"`
+}
+
+// UserURL сокращенный урл, принадлежащий пользователю
+type UserURL struct {
+	ShortenID ShortenID
+	FullURL   FullURL
+}
+
+// DeleteTask задача на удаление сокращенного урла от имени пользователя
+type DeleteTask struct {
+	UserID    UserID
+	ShortenID ShortenID
+}
+
+// URLOptions параметры сокращенного урла, задаваемые при сохранении
+type URLOptions struct {
+	// ExpiresAt момент, после которого урл перестает открываться
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// MaxClicks допустимое число переходов, 0 — без ограничения
+	MaxClicks int64 `json:"max_clicks,omitempty"`
+	// RedirectCode код ответа при переходе, 0 — код по умолчанию из конфигов
+	RedirectCode int `json:"redirect_code,omitempty"`
+	// Passthrough урл работает как префикс: суффикс пути и параметры запроса
+	// при переходе переносятся в целевой урл
+	Passthrough bool `json:"passthrough,omitempty"`
+	// UTM метки, добавляемые к целевому урлу при переходе
+	UTM *UTM `json:"utm,omitempty"`
+	// PasswordHash bcrypt хэш пароля, без которого переход не выполняется
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// Limited проверяет, что у урла задан срок жизни или лимит переходов
+func (o URLOptions) Limited() bool {
+	return o.ExpiresAt != nil || o.MaxClicks > 0
+}
+
+// Distinct проверяет, что урл с такими параметрами сохраняется отдельной записью
+// и не участвует в дедупликации
+func (o URLOptions) Distinct() bool {
+	return o.Limited() || o.RedirectCode != 0 || o.Passthrough || o.UTM != nil || o.PasswordHash != ""
+}
+
+// Expired проверяет, истек ли урл к моменту now после clicks переходов
+func (o URLOptions) Expired(now time.Time, clicks int64) bool {
+	if o.ExpiresAt != nil && !now.Before(*o.ExpiresAt) {
+		return true
+	}
+	return o.MaxClicks > 0 && clicks >= o.MaxClicks
+}
+
+// UTM метки кампании, добавляемые к целевому урлу как параметры utm_*
+type UTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// IsZero проверяет, что ни одна метка не задана
+func (u UTM) IsZero() bool {
+	return u == UTM{}
+}
+
+// WithDefaults возвращает метки u, в которых незаданные поля взяты из defaults.
+// Если в результате не задана ни одна метка, возвращает nil
+func (u *UTM) WithDefaults(defaults *UTM) *UTM {
+	var res UTM
+	if u != nil {
+		res = *u
+	}
+	if defaults != nil {
+		res.Source = orDefault(res.Source, defaults.Source)
+		res.Medium = orDefault(res.Medium, defaults.Medium)
+		res.Campaign = orDefault(res.Campaign, defaults.Campaign)
+		res.Term = orDefault(res.Term, defaults.Term)
+		res.Content = orDefault(res.Content, defaults.Content)
+	}
+	if res.IsZero() {
+		return nil
+	}
+	return &res
+}
+
+func orDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+// Target цель перехода по сокращенному урлу
+type Target struct {
+	FullURL FullURL `json:"url"`
+	URLOptions
+}
+
+// Clone возвращает копию цели, не разделяющую с ней ExpiresAt и UTM
+func (t Target) Clone() Target {
+	if t.ExpiresAt != nil {
+		expiresAt := *t.ExpiresAt
+		t.ExpiresAt = &expiresAt
+	}
+	if t.UTM != nil {
+		utm := *t.UTM
+		t.UTM = &utm
+	}
+	return t
+}
+
+// Equal проверяет, что цели совпадают. Сроки жизни сравниваются как моменты времени
+func (t Target) Equal(o Target) bool {
+	if (t.ExpiresAt == nil) != (o.ExpiresAt == nil) || (t.UTM == nil) != (o.UTM == nil) {
+		return false
+	}
+	if t.ExpiresAt != nil && !t.ExpiresAt.Equal(*o.ExpiresAt) {
+		return false
+	}
+	if t.UTM != nil && *t.UTM != *o.UTM {
+		return false
+	}
+	return t.FullURL == o.FullURL && t.MaxClicks == o.MaxClicks && t.RedirectCode == o.RedirectCode &&
+		t.Passthrough == o.Passthrough && t.PasswordHash == o.PasswordHash
+}
+
+// URLChange изменение целевого урла и параметров сокращенного урла его владельцем
+type URLChange struct {
+	UserID    UserID    `json:"user_id"`
+	ChangedAt time.Time `json:"changed_at"`
+	Old       Target    `json:"old"`
+	New       Target    `json:"new"`
+}
+
+// URLEntry сохраненный сокращенный урл вместе со служебными данными
+type URLEntry struct {
+	ShortenID ShortenID
+	UserID    UserID
+	// CreatedAt момент сохранения урла, нулевой, если хранилище его не знает
+	CreatedAt time.Time
+	Target
+	IsDeleted bool
+	// IsExpired урл истек по сроку жизни или лимиту переходов
+	IsExpired bool
+	// Clicks число переходов по урлу, учтенных в статистике
+	Clicks int64
+}
+
+// SetOptions необязательные параметры сохранения урла
+type SetOptions struct {
+	// Alias желаемый короткий идентификатор вместо сгенерированного
+	Alias ShortenID
+	URLOptions
+}
+
+// BatchItem урл для пакетного сохранения
+type BatchItem struct {
+	FullURL FullURL
+	SetOptions
+}
+
+// BatchResult результат сохранения урла из пакета
+type BatchResult struct {
+	ShortenID ShortenID
+	// Created урл сохранен этим пакетом. Иначе ShortenID указывает на сохраненный ранее урл
+	Created bool
+	// Err причина, по которой урл не сохранен, например занятый alias
+	Err error
+}
+
+// ClickEvent переход по сокращенному урлу
+type ClickEvent struct {
+	ShortenID ShortenID `json:"-"`
+	Time      time.Time `json:"time"`
+	// Referrer хост страницы, с которой пришел переход, пустой для прямых переходов
+	Referrer  string `json:"referrer,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	// IPHash хэш адреса клиента, по которому считаются уникальные посетители
+	IPHash string `json:"ip_hash,omitempty"`
+}
+
+// ClickStatsDelta прирост агрегатов статистики урла. Сырые события в нем не хранятся
+type ClickStatsDelta struct {
+	// ByDay число переходов по дням в UTC, ключ в формате 2006-01-02
+	ByDay map[string]int64 `json:"by_day"`
+	// ByReferrer число переходов по хостам источников
+	ByReferrer map[string]int64 `json:"by_referrer"`
+	// Visitors хэши адресов посетителей, ранее не переходивших по урлу
+	Visitors []string `json:"visitors,omitempty"`
+}
+
+// URLStats агрегированная статистика переходов по сокращенному урлу
+type URLStats struct {
+	TotalClicks    int64
+	UniqueVisitors int64
+	// ByDay число переходов по дням в UTC, ключ в формате 2006-01-02
+	ByDay map[string]int64
+	// ByReferrer число переходов по хостам источников
+	ByReferrer map[string]int64
 }
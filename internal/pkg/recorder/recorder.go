@@ -0,0 +1,107 @@
+package recorder
+
+import (
+	"context"
+	"time"
+
+	"github.com/nartim88/urlshortener/internal/pkg/logger"
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+	"github.com/nartim88/urlshortener/internal/pkg/storage"
+)
+
+const (
+	// bufferSize емкость очереди событий. При переполнении новые события отбрасываются,
+	// чтобы запись статистики не замедляла редиректы
+	bufferSize = 4096
+	// batchSize количество событий, при накоплении которого пачка сохраняется сразу
+	batchSize = 500
+	// flushInterval максимальное время ожидания событий перед сохранением неполной пачки
+	flushInterval = 5 * time.Second
+	flushTimeout  = 30 * time.Second
+)
+
+// Recorder в фоне сохраняет переходы по урлам, собирая события из всех запросов
+// в общий буфер и отправляя их в хранилище пачками
+type Recorder struct {
+	store  storage.Storage
+	events chan models.ClickEvent
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+// New инициализирует Recorder для хранилища store
+func New(store storage.Storage) *Recorder {
+	return &Recorder{
+		store:  store,
+		events: make(chan models.ClickEvent, bufferSize),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Record ставит событие в очередь не блокируясь. Если очередь заполнена, событие теряется
+func (r *Recorder) Record(e models.ClickEvent) {
+	select {
+	case r.events <- e:
+	default:
+		logger.Log.Warn().Str("shorten_id", string(e.ShortenID)).Msg("click events buffer is full, event is dropped")
+	}
+}
+
+// Run обрабатывает очередь, пока не будет вызван Close
+func (r *Recorder) Run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.ClickEvent, 0, batchSize)
+
+	for {
+		select {
+		case e := <-r.events:
+			batch = append(batch, e)
+			if len(batch) >= batchSize {
+				batch = r.flush(batch)
+			}
+		case <-ticker.C:
+			batch = r.flush(batch)
+		case <-r.quit:
+			for {
+				select {
+				case e := <-r.events:
+					batch = append(batch, e)
+					if len(batch) >= batchSize {
+						batch = r.flush(batch)
+					}
+				default:
+					r.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close останавливает Run, сохранив накопленные события
+func (r *Recorder) Close() {
+	close(r.quit)
+	<-r.done
+}
+
+// flush сохраняет пачку событий и возвращает пустой слайс для накопления следующей
+func (r *Recorder) flush(batch []models.ClickEvent) []models.ClickEvent {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	if err := r.store.SaveClicks(ctx, batch); err != nil {
+		logger.Log.Error().Err(err).Int("count", len(batch)).Msg("error while saving click events")
+	} else {
+		logger.Log.Debug().Int("count", len(batch)).Msg("click events are saved")
+	}
+	return batch[:0]
+}
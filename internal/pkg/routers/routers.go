@@ -34,7 +34,13 @@ func textRespRouter() http.Handler {
 
 		r.Route("/{id}", func(r chi.Router) {
 			r.Get("/", handlers.GetURLHandle)
+			r.Get("/*", handlers.GetURLHandle)
+			// форма ввода пароля защищенного урла
+			r.Post("/", handlers.GetURLHandle)
+			r.Post("/*", handlers.GetURLHandle)
 		})
+		// страница предпросмотра: куда ведет короткая ссылка
+		r.Get("/{id}+", handlers.PreviewURLHandle)
 	})
 	return r
 }
@@ -49,6 +55,31 @@ func apiRouter() http.Handler {
 			r.Route("/batch", func(r chi.Router) {
 				r.Post("/", handlers.GetBatchShortURLsHandle)
 			})
+
+			r.Post("/stream", handlers.GetStreamShortURLsHandle)
+		})
+
+		r.Get("/lookup", handlers.LookupURLHandle)
+
+		// урлы отдаются и меняются только владельцем, так что неверная cookie дает 401, а не 403 чужого урла
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireAuth)
+
+			r.Get("/urls/{id}", handlers.GetURLInfoHandle)
+			r.Get("/urls/{id}/stats", handlers.GetURLStatsHandle)
+			r.Get("/urls/{id}/history", handlers.GetURLHistoryHandle)
+			r.Patch("/urls/{id}", handlers.EditURLHandle)
+		})
+
+		r.Route("/user", func(r chi.Router) {
+			r.Use(middleware.RequireAuth)
+
+			r.Get("/urls", handlers.GetUserURLsHandle)
+			r.Delete("/urls", handlers.DeleteUserURLsHandle)
+
+			r.Get("/utm", handlers.GetUTMDefaultsHandle)
+			r.Put("/utm", handlers.SetUTMDefaultsHandle)
+			r.Delete("/utm", handlers.DeleteUTMDefaultsHandle)
 		})
 	})
 
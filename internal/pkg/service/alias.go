@@ -0,0 +1,46 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+const (
+	minAliasLen = 3
+	maxAliasLen = 64
+)
+
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// reservedAliases первые сегменты путей, занятые маршрутами сервиса
+var reservedAliases = map[string]struct{}{
+	"api":  {},
+	"ping": {},
+}
+
+// AliasError пользовательский alias не прошел проверку
+type AliasError struct {
+	Alias  models.ShortenID
+	Reason string
+}
+
+func (e AliasError) Error() string {
+	return fmt.Sprintf("alias '%s' is invalid: %s", e.Alias, e.Reason)
+}
+
+// ValidateAlias проверяет длину, допустимые символы и то, что alias не совпадает с маршрутами сервиса
+func ValidateAlias(alias models.ShortenID) error {
+	switch {
+	case len(alias) < minAliasLen || len(alias) > maxAliasLen:
+		return AliasError{alias, fmt.Sprintf("length must be between %d and %d", minAliasLen, maxAliasLen)}
+	case !aliasPattern.MatchString(string(alias)):
+		return AliasError{alias, "only latin letters, digits, '-' and '_' are allowed"}
+	}
+	if _, ok := reservedAliases[strings.ToLower(string(alias))]; ok {
+		return AliasError{alias, "alias is reserved"}
+	}
+	return nil
+}
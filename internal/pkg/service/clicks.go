@@ -0,0 +1,35 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/url"
+)
+
+// HashIP возвращает HMAC-SHA256 адреса клиента на ключе key. Хэш позволяет считать
+// уникальных посетителей, не храня сами адреса
+func HashIP(remoteAddr string, key string) string {
+	ip := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ip = host
+	}
+	if ip == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ReferrerHost возвращает хост из заголовка Referer. Для пустого или
+// некорректного заголовка возвращается пустая строка
+func ReferrerHost(referrer string) string {
+	u, err := url.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+// ValidateURLOptions проверяет, что срок жизни урла еще не истек к моменту now,
+// а лимит переходов не отрицательный
+func ValidateURLOptions(opts models.URLOptions, now time.Time) error {
+	if opts.ExpiresAt != nil && !opts.ExpiresAt.After(now) {
+		return errors.New("expires_at must be in the future")
+	}
+	if opts.MaxClicks < 0 {
+		return errors.New("max_clicks must not be negative")
+	}
+	return nil
+}
+
+// SetOneTime делает урл одноразовым. Одноразовый урл — это урл с лимитом в один
+// переход, так что первый переход гасит его тем же атомарным учетом переходов,
+// что и любой урл с лимитом
+func SetOneTime(opts *models.URLOptions) error {
+	if opts.MaxClicks > 1 {
+		return errors.New("one_time conflicts with max_clicks greater than 1")
+	}
+	opts.MaxClicks = 1
+	return nil
+}
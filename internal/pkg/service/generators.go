@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+// ErrSequenceExhausted значение счетчика не помещается в идентификатор заданной длины
+var ErrSequenceExhausted = errors.New("sequence is exhausted for the given id length")
+
+// maxObfuscatedLength максимальная длина идентификатора, при которой 62^length помещается в uint64
+const maxObfuscatedLength = 10
+
+// RandomGenerator генерирует идентификаторы из криптографически случайных символов base62
+type RandomGenerator struct {
+	Length int
+}
+
+func (g RandomGenerator) Generate(ctx context.Context, fURL models.FullURL, attempt int) (models.ShortenID, error) {
+	// байты >= 248 отбрасываются, чтобы остаток от деления на 62 был равномерным
+	const maxByte = 256 - 256%len(charset)
+
+	id := make([]byte, 0, g.Length)
+	buf := make([]byte, g.Length*2)
+	for len(id) < g.Length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) >= maxByte {
+				continue
+			}
+			id = append(id, charset[int(b)%len(charset)])
+			if len(id) == g.Length {
+				break
+			}
+		}
+	}
+	return models.ShortenID(id), nil
+}
+
+// Resumable реализуют генераторы со счетчиком в памяти, который после восстановления
+// данных хранилища нужно продолжить с уже использованных значений
+type Resumable interface {
+	Resume(n uint64)
+}
+
+// CounterGenerator кодирует в base62 следующее значение счетчика, дополняя его до Length
+type CounterGenerator struct {
+	Seq    Sequence
+	Length int
+}
+
+func (g CounterGenerator) Generate(ctx context.Context, fURL models.FullURL, attempt int) (models.ShortenID, error) {
+	n, err := g.Seq.Next(ctx)
+	if err != nil {
+		return "", err
+	}
+	return models.ShortenID(encodeBase62(n, g.Length, charset)), nil
+}
+
+func (g CounterGenerator) Resume(n uint64) {
+	if r, ok := g.Seq.(Resumable); ok {
+		r.Resume(n)
+	}
+}
+
+// HashGenerator детерминированно получает идентификатор из хэша полного урла,
+// так что один и тот же урл всегда получает один и тот же идентификатор
+type HashGenerator struct {
+	Length int
+}
+
+func (g HashGenerator) Generate(ctx context.Context, fURL models.FullURL, attempt int) (models.ShortenID, error) {
+	data := string(fURL)
+	if attempt > 0 {
+		data += "#" + strconv.Itoa(attempt)
+	}
+	sum := sha256.Sum256([]byte(data))
+
+	id := make([]byte, 0, g.Length)
+	for len(id) < g.Length {
+		for i := 0; i < len(sum) && len(id) < g.Length; i += 8 {
+			n := binary.BigEndian.Uint64(sum[i : i+8])
+			for j := 0; j < 10 && len(id) < g.Length; j++ {
+				id = append(id, charset[n%uint64(len(charset))])
+				n /= uint64(len(charset))
+			}
+		}
+		sum = sha256.Sum256(sum[:])
+	}
+	return models.ShortenID(id), nil
+}
+
+// ObfuscatedGenerator в духе Hashids/Sqids превращает значения счетчика в идентификаторы
+// фиксированной длины, не выдающие порядок создания: значение переводится биекцией
+// n -> (n*multiplier + offset) mod 62^Length и кодируется перемешанным по соли алфавитом
+type ObfuscatedGenerator struct {
+	seq        Sequence
+	length     int
+	space      uint64
+	multiplier uint64
+	offset     uint64
+	alphabet   string
+}
+
+// NewObfuscatedGenerator инициализирует ObfuscatedGenerator, параметры биекции и алфавит
+// выводятся из salt
+func NewObfuscatedGenerator(seq Sequence, length int, salt string) (*ObfuscatedGenerator, error) {
+	if length > maxObfuscatedLength {
+		return nil, fmt.Errorf("obfuscated id length must be at most %d, got %d", maxObfuscatedLength, length)
+	}
+
+	space := uint64(1)
+	for i := 0; i < length; i++ {
+		space *= uint64(len(charset))
+	}
+
+	sum := sha256.Sum256([]byte(salt))
+
+	// множитель должен быть взаимно прост с 62^length, то есть не делиться на 2 и 31
+	multiplier := binary.BigEndian.Uint64(sum[0:8])%space | 1
+	for multiplier%31 == 0 {
+		multiplier = (multiplier + 2) % space
+	}
+
+	return &ObfuscatedGenerator{
+		seq:        seq,
+		length:     length,
+		space:      space,
+		multiplier: multiplier,
+		offset:     binary.BigEndian.Uint64(sum[8:16]) % space,
+		alphabet:   shuffle(charset, sum[16:]),
+	}, nil
+}
+
+func (g *ObfuscatedGenerator) Generate(ctx context.Context, fURL models.FullURL, attempt int) (models.ShortenID, error) {
+	n, err := g.seq.Next(ctx)
+	if err != nil {
+		return "", err
+	}
+	if n >= g.space {
+		return "", ErrSequenceExhausted
+	}
+
+	hi, lo := bits.Mul64(n, g.multiplier)
+	lo, carry := bits.Add64(lo, g.offset, 0)
+	hi += carry
+	v := bits.Rem64(hi, lo, g.space)
+
+	return models.ShortenID(encodeBase62(v, g.length, g.alphabet)), nil
+}
+
+func (g *ObfuscatedGenerator) Resume(n uint64) {
+	if r, ok := g.seq.(Resumable); ok {
+		r.Resume(n)
+	}
+}
+
+// MemSequence счетчик в памяти процесса
+type MemSequence struct {
+	n atomic.Uint64
+}
+
+// NewMemSequence инициализирует MemSequence, начинающийся с 1
+func NewMemSequence() *MemSequence {
+	return &MemSequence{}
+}
+
+func (s *MemSequence) Next(ctx context.Context) (uint64, error) {
+	return s.n.Add(1), nil
+}
+
+// Resume сдвигает счетчик так, чтобы следующее значение было больше n.
+// Используется хранилищами, восстанавливающими данные после перезапуска
+func (s *MemSequence) Resume(n uint64) {
+	for {
+		cur := s.n.Load()
+		if cur >= n || s.n.CompareAndSwap(cur, n) {
+			return
+		}
+	}
+}
+
+// encodeBase62 кодирует n алфавитом из 62 символов, дополняя результат до minLen
+func encodeBase62(n uint64, minLen int, alphabet string) string {
+	base := uint64(len(alphabet))
+	var res []byte
+	for n > 0 || len(res) < minLen {
+		res = append(res, alphabet[n%base])
+		n /= base
+	}
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return string(res)
+}
+
+// shuffle детерминированно перемешивает алфавит, используя key как источник псевдослучайности
+func shuffle(alphabet string, key []byte) string {
+	res := []byte(alphabet)
+	h := sha256.Sum256(key)
+	for i := len(res) - 1; i > 0; i-- {
+		if i%8 == 0 {
+			h = sha256.Sum256(h[:])
+		}
+		j := int(binary.BigEndian.Uint32(h[(i%8)*4:(i%8)*4+4]) % uint32(i+1))
+		res[i], res[j] = res[j], res[i]
+	}
+	return string(res)
+}
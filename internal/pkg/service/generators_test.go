@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+func TestIDGenerators(t *testing.T) {
+	ctx := context.Background()
+	const length, count = 8, 10000
+
+	for _, strategy := range []string{StrategyRandom, StrategyCounter, StrategyHash, StrategyObfuscated} {
+		t.Run(strategy, func(t *testing.T) {
+			gen, err := NewIDGenerator(strategy, length, "salt", NewMemSequence())
+			require.NoError(t, err)
+
+			seen := make(map[models.ShortenID]struct{}, count)
+			for i := 0; i < count; i++ {
+				fURL := models.FullURL(fmt.Sprintf("https://example.com/%d", i))
+				sID, err := gen.Generate(ctx, fURL, 0)
+				require.NoError(t, err)
+
+				assert.Len(t, sID, length)
+				for _, c := range sID {
+					assert.True(t, strings.ContainsRune(charset, c), "unexpected char %q in %s", c, sID)
+				}
+				seen[sID] = struct{}{}
+			}
+			assert.Len(t, seen, count, "ids must not repeat")
+		})
+	}
+}
+
+func TestHashGeneratorIsDeterministic(t *testing.T) {
+	ctx := context.Background()
+	gen := HashGenerator{Length: 8}
+	fURL := models.FullURL("https://ya.ru")
+
+	first, err := gen.Generate(ctx, fURL, 0)
+	require.NoError(t, err)
+	second, err := gen.Generate(ctx, fURL, 0)
+	require.NoError(t, err)
+	retry, err := gen.Generate(ctx, fURL, 1)
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second)
+	assert.NotEqual(t, first, retry)
+}
+
+func TestObfuscatedGeneratorIsNotSequential(t *testing.T) {
+	ctx := context.Background()
+	gen, err := NewObfuscatedGenerator(NewMemSequence(), 8, "salt")
+	require.NoError(t, err)
+
+	first, err := gen.Generate(ctx, "", 0)
+	require.NoError(t, err)
+	second, err := gen.Generate(ctx, "", 0)
+	require.NoError(t, err)
+
+	assert.NotEqual(t, first[:4], second[:4])
+}
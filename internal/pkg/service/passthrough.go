@@ -0,0 +1,76 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+// Политики слияния параметров запроса с параметрами целевого урла при совпадении ключей
+const (
+	// QueryPolicyTarget остаются значения целевого урла
+	QueryPolicyTarget = "target"
+	// QueryPolicyRequest значения целевого урла заменяются значениями из запроса
+	QueryPolicyRequest = "request"
+	// QueryPolicyBoth значения из запроса добавляются после значений целевого урла
+	QueryPolicyBoth = "both"
+)
+
+// ErrInvalidSuffix суффикс пути содержит сегменты . или .., которые могли бы вывести
+// переход за пределы раздела, на который указывает урл
+var ErrInvalidSuffix = errors.New("path suffix must not contain dot segments")
+
+// ValidateQueryPolicy проверяет политику слияния параметров запроса
+func ValidateQueryPolicy(policy string) error {
+	switch policy {
+	case QueryPolicyTarget, QueryPolicyRequest, QueryPolicyBoth:
+		return nil
+	}
+	return fmt.Errorf("unknown query policy %q", policy)
+}
+
+// Passthrough дописывает к целевому урлу суффикс пути escapedSuffix в экранированном
+// виде и сливает query с параметрами целевого урла по политике policy
+func Passthrough(target models.FullURL, escapedSuffix string, query url.Values, policy string) (models.FullURL, error) {
+	u, err := url.Parse(string(target))
+	if err != nil {
+		return "", err
+	}
+
+	if escapedSuffix != "" {
+		// сегменты проверяются после разэкранирования, чтобы не пропустить %2e%2e
+		suffix, err := url.PathUnescape(escapedSuffix)
+		if err != nil {
+			return "", err
+		}
+		for _, segment := range strings.Split(suffix, "/") {
+			if segment == "." || segment == ".." {
+				return "", ErrInvalidSuffix
+			}
+		}
+		rawPath := strings.TrimSuffix(u.EscapedPath(), "/") + "/" + escapedSuffix
+		if u.Path, err = url.PathUnescape(rawPath); err != nil {
+			return "", err
+		}
+		u.RawPath = rawPath
+	}
+
+	if len(query) > 0 {
+		merged := u.Query()
+		for key, values := range query {
+			_, exists := merged[key]
+			switch {
+			case !exists, policy == QueryPolicyRequest:
+				merged[key] = values
+			case policy == QueryPolicyBoth:
+				merged[key] = append(merged[key], values...)
+			}
+		}
+		u.RawQuery = merged.Encode()
+	}
+
+	return models.FullURL(u.String()), nil
+}
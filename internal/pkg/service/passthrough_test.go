@@ -0,0 +1,47 @@
+package service
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+func TestPassthrough(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		target models.FullURL
+		suffix string
+		query  string
+		policy string
+		want   models.FullURL
+	}{
+		{name: "nothing", target: "https://ya.ru/docs?b=2&a=1", policy: QueryPolicyTarget, want: "https://ya.ru/docs?b=2&a=1"},
+		{name: "suffix", target: "https://ya.ru/docs/", suffix: "guide/page", policy: QueryPolicyTarget, want: "https://ya.ru/docs/guide/page"},
+		{name: "suffix_to_root", target: "https://ya.ru", suffix: "page", policy: QueryPolicyTarget, want: "https://ya.ru/page"},
+		{name: "escaped_suffix", target: "https://ya.ru/docs", suffix: "a%2Fb/c%20d", policy: QueryPolicyTarget, want: "https://ya.ru/docs/a%2Fb/c%20d"},
+		{name: "keeps_fragment", target: "https://ya.ru/docs#top", suffix: "page", query: "x=1", policy: QueryPolicyTarget, want: "https://ya.ru/docs/page?x=1#top"},
+		{name: "policy_target", target: "https://ya.ru/?a=1", query: "a=2&b=3", policy: QueryPolicyTarget, want: "https://ya.ru/?a=1&b=3"},
+		{name: "policy_request", target: "https://ya.ru/?a=1", query: "a=2&b=3", policy: QueryPolicyRequest, want: "https://ya.ru/?a=2&b=3"},
+		{name: "policy_both", target: "https://ya.ru/?a=1", query: "a=2&b=3", policy: QueryPolicyBoth, want: "https://ya.ru/?a=1&a=2&b=3"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tc.query)
+			require.NoError(t, err)
+
+			got, err := Passthrough(tc.target, tc.suffix, query, tc.policy)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	for _, suffix := range []string{"..", "a/../../admin", "./a", "%2e%2e/admin", "a%2F..%2Fadmin"} {
+		_, err := Passthrough("https://ya.ru/docs", suffix, nil, QueryPolicyTarget)
+		assert.ErrorIs(t, err, ErrInvalidSuffix, suffix)
+	}
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+// maxPasswordLen максимальная длина пароля ссылки, больше bcrypt не учитывает
+const maxPasswordLen = 72
+
+// ErrPasswordTooLong пароль длиннее, чем учитывает bcrypt
+var ErrPasswordTooLong = errors.New("password must be at most 72 bytes long")
+
+// HashPassword возвращает bcrypt хэш пароля ссылки. Для пустого пароля возвращает
+// пустую строку
+func HashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	if len(password) > maxPasswordLen {
+		return "", ErrPasswordTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword проверяет пароль по bcrypt хэшу
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// AttemptLimiter ограничивает число неудачных попыток ввода пароля ссылки за окно времени
+type AttemptLimiter struct {
+	maxFailures int
+	window      time.Duration
+
+	mu        sync.Mutex
+	attempts  map[models.ShortenID]attemptWindow
+	lastPrune time.Time
+}
+
+// attemptWindow неудачные попытки, начиная с момента start
+type attemptWindow struct {
+	start    time.Time
+	failures int
+}
+
+// NewAttemptLimiter инициализирует AttemptLimiter, допускающий maxFailures неудачных
+// попыток за window
+func NewAttemptLimiter(maxFailures int, window time.Duration) *AttemptLimiter {
+	return &AttemptLimiter{
+		maxFailures: maxFailures,
+		window:      window,
+		attempts:    make(map[models.ShortenID]attemptWindow),
+	}
+}
+
+// Allow резервирует попытку по ссылке в момент now. Попытка сразу считается неудачной,
+// так что конкурентные попытки не проходят сверх лимита, пока пароли проверяются.
+// Если лимит исчерпан, возвращает время до окончания окна
+func (l *AttemptLimiter) Allow(sID models.ShortenID, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+	a, ok := l.attempts[sID]
+	if !ok || now.Sub(a.start) >= l.window {
+		a = attemptWindow{start: now}
+	}
+	if a.failures >= l.maxFailures {
+		return false, a.start.Add(l.window).Sub(now)
+	}
+	a.failures++
+	l.attempts[sID] = a
+	return true, 0
+}
+
+// Release возвращает попытку, зарезервированную Allow в момент reservedAt, если она
+// оказалась удачной. Попытка из уже закончившегося окна не возвращается
+func (l *AttemptLimiter) Release(sID models.ShortenID, reservedAt time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.attempts[sID]
+	if !ok || a.failures == 0 || a.start.After(reservedAt) {
+		return
+	}
+	a.failures--
+	l.attempts[sID] = a
+}
+
+// prune раз в окно удаляет истекшие окна, чтобы карта не росла бесконечно
+func (l *AttemptLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.window {
+		return
+	}
+	for sID, a := range l.attempts {
+		if now.Sub(a.start) >= l.window {
+			delete(l.attempts, sID)
+		}
+	}
+	l.lastPrune = now
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("secret")
+	require.NoError(t, err)
+	assert.NotContains(t, hash, "secret")
+	assert.True(t, CheckPassword(hash, "secret"))
+	assert.False(t, CheckPassword(hash, "Secret"))
+
+	hash, err = HashPassword("")
+	require.NoError(t, err)
+	assert.Empty(t, hash)
+
+	_, err = HashPassword(strings.Repeat("a", maxPasswordLen+1))
+	assert.ErrorIs(t, err, ErrPasswordTooLong)
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	l := NewAttemptLimiter(2, time.Minute)
+	now := time.Now()
+
+	ok, _ := l.Allow("a", now)
+	assert.True(t, ok)
+	ok, _ = l.Allow("a", now.Add(time.Second))
+	assert.True(t, ok)
+	ok, retryAfter := l.Allow("a", now.Add(10*time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, 50*time.Second, retryAfter)
+
+	ok, _ = l.Allow("b", now)
+	assert.True(t, ok, "attempts are counted per link")
+
+	ok, _ = l.Allow("a", now.Add(time.Minute))
+	assert.True(t, ok, "window is over")
+}
+
+func TestAttemptLimiterRelease(t *testing.T) {
+	l := NewAttemptLimiter(1, time.Minute)
+	now := time.Now()
+
+	ok, _ := l.Allow("a", now)
+	require.True(t, ok)
+	l.Release("a", now)
+	ok, _ = l.Allow("a", now)
+	assert.True(t, ok, "successful attempt is given back")
+
+	ok, _ = l.Allow("a", now)
+	assert.False(t, ok)
+	l.Release("a", now.Add(-time.Minute))
+	ok, _ = l.Allow("a", now)
+	assert.False(t, ok, "attempt from a previous window is not given back")
+}
+
+func TestAttemptLimiterConcurrent(t *testing.T) {
+	const maxFailures = 5
+	l := NewAttemptLimiter(maxFailures, time.Minute)
+	now := time.Now()
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := l.Allow("a", now); ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(maxFailures), allowed.Load())
+}
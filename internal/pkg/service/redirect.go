@@ -0,0 +1,29 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// redirectCodes коды ответа, которыми можно отвечать при переходе по урлу
+var redirectCodes = map[int]struct{}{
+	http.StatusMovedPermanently:  {},
+	http.StatusFound:             {},
+	http.StatusSeeOther:          {},
+	http.StatusTemporaryRedirect: {},
+	http.StatusPermanentRedirect: {},
+}
+
+// ValidateRedirectCode проверяет, что code — код ответа редиректа
+func ValidateRedirectCode(code int) error {
+	if _, ok := redirectCodes[code]; !ok {
+		return fmt.Errorf("redirect_code must be one of 301, 302, 303, 307 or 308, got %d", code)
+	}
+	return nil
+}
+
+// IsPermanentRedirect проверяет, что редирект с кодом code клиенты могут кэшировать
+// как постоянный
+func IsPermanentRedirect(code int) bool {
+	return code == http.StatusMovedPermanently || code == http.StatusPermanentRedirect
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+// Политики обработки урлов, ведущих на короткие ссылки самого сервиса
+const (
+	// SelfLinkReject такие урлы отклоняются
+	SelfLinkReject = "reject"
+	// SelfLinkResolve вместо новой ссылки возвращается уже существующая короткая ссылка
+	SelfLinkResolve = "resolve"
+)
+
+// Resolver возвращает полный урл по короткому идентификатору, как Storage.Get
+type Resolver interface {
+	Get(ctx context.Context, sID models.ShortenID) (*models.FullURL, error)
+}
+
+// SelfLinkError урл ведет на сам сервис и не может быть сокращен
+type SelfLinkError struct {
+	URL    models.FullURL
+	Reason string
+}
+
+func (e SelfLinkError) Error() string {
+	return fmt.Sprintf("url '%s' can't be shortened: %s", e.URL, e.Reason)
+}
+
+// SelfLinkGuard защищает от цепочек и циклов редиректов, распознавая урлы,
+// которые ведут на короткие ссылки самого сервиса
+type SelfLinkGuard struct {
+	// prefixes путь, под которым доступны короткие ссылки, по хостам сервиса
+	prefixes map[string]string
+	policy   string
+	maxDepth int
+	// signer проверяет подпись ссылок сервиса
+	signer *LinkSigner
+}
+
+// NewSelfLinkGuard инициализирует SelfLinkGuard. Хосты сервиса берутся из baseURL
+// и aliasHosts, элементы aliasHosts могут быть как хостами, так и урлами. signer
+// извлекает идентификатор из подписанных ссылок, nil — ссылки не подписываются
+func NewSelfLinkGuard(
+	baseURL string, aliasHosts []string, policy string, maxDepth int, signer *LinkSigner,
+) (*SelfLinkGuard, error) {
+	switch policy {
+	case SelfLinkReject, SelfLinkResolve:
+	default:
+		return nil, fmt.Errorf("unknown self link policy %q", policy)
+	}
+	if maxDepth <= 0 {
+		return nil, fmt.Errorf("max redirect depth must be positive, got %d", maxDepth)
+	}
+
+	g := SelfLinkGuard{
+		prefixes: make(map[string]string),
+		policy:   policy,
+		maxDepth: maxDepth,
+		signer:   signer,
+	}
+	for _, raw := range append([]string{baseURL}, aliasHosts...) {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		if !strings.Contains(raw, "://") {
+			raw = "http://" + raw
+		}
+		fURL, err := NormalizeURL(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service host: %w", err)
+		}
+		u, _ := url.Parse(string(fURL))
+		g.prefixes[u.Host] = strings.TrimSuffix(u.Path, "/")
+	}
+	return &g, nil
+}
+
+// Check проверяет нормализованный урл. Для урла, не ведущего на сервис, возвращает
+// пустой идентификатор. Для урла на короткую ссылку сервиса по политике resolve
+// проходит цепочку ссылок не глубже maxDepth и возвращает последнюю ссылку, ведущую
+// за пределы сервиса, по политике reject — SelfLinkError
+func (g *SelfLinkGuard) Check(ctx context.Context, r Resolver, fURL models.FullURL) (models.ShortenID, error) {
+	sID, ok, err := g.shortenID(fURL)
+	if !ok {
+		return "", err
+	}
+	if g.policy == SelfLinkReject {
+		return "", SelfLinkError{fURL, "url points to a short link of this service"}
+	}
+
+	for depth := 0; depth < g.maxDepth; depth++ {
+		target, err := r.Get(ctx, sID)
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return "", err
+			}
+			return "", SelfLinkError{fURL, "short link is not available"}
+		}
+		if target == nil {
+			return "", SelfLinkError{fURL, "short link doesn't exist"}
+		}
+
+		next, ok, err := g.shortenID(*target)
+		if !ok {
+			if err != nil {
+				return "", err
+			}
+			return sID, nil
+		}
+		sID = next
+	}
+	return "", SelfLinkError{fURL, fmt.Sprintf("redirect chain is longer than %d links", g.maxDepth)}
+}
+
+// shortenID возвращает короткий идентификатор, если урл ведет на сервис. Урл на
+// сервис, не являющийся короткой ссылкой, возвращает SelfLinkError
+func (g *SelfLinkGuard) shortenID(fURL models.FullURL) (models.ShortenID, bool, error) {
+	u, err := url.Parse(string(fURL))
+	if err != nil {
+		return "", false, nil
+	}
+	prefix, ok := g.prefixes[strings.ToLower(u.Host)]
+	if !ok {
+		return "", false, nil
+	}
+
+	rest, ok := strings.CutPrefix(u.Path, prefix+"/")
+	if !ok || rest == "" || strings.Contains(rest, "/") {
+		return "", true, SelfLinkError{fURL, "url points to this service"}
+	}
+	sID, err := g.signer.Verify(rest)
+	if err != nil {
+		return "", true, SelfLinkError{fURL, err.Error()}
+	}
+	return sID, true, nil
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+// mapResolver Resolver поверх map
+type mapResolver map[models.ShortenID]models.FullURL
+
+func (m mapResolver) Get(_ context.Context, sID models.ShortenID) (*models.FullURL, error) {
+	fURL, ok := m[sID]
+	if !ok {
+		return nil, nil
+	}
+	return &fURL, nil
+}
+
+func TestSelfLinkGuard(t *testing.T) {
+	ctx := context.Background()
+	r := mapResolver{
+		"direct": "https://ya.ru",
+		"hop1":   "http://localhost:8080/direct",
+		"hop2":   "https://sho.rt/s/hop1",
+		"loop":   "http://localhost:8080/loop",
+	}
+
+	g, err := NewSelfLinkGuard("http://localhost:8080", []string{"https://SHO.RT/s/", " "}, SelfLinkResolve, 3, nil)
+	require.NoError(t, err)
+
+	var testCases = []struct {
+		name    string
+		fURL    models.FullURL
+		want    models.ShortenID
+		wantErr bool
+	}{
+		{name: "foreign_url", fURL: "https://ya.ru/direct"},
+		{name: "direct", fURL: "http://localhost:8080/direct", want: "direct"},
+		{name: "alias_host", fURL: "https://sho.rt/s/direct", want: "direct"},
+		{name: "chain", fURL: "https://sho.rt/s/hop2", want: "direct"},
+		{name: "loop", fURL: "http://localhost:8080/loop", wantErr: true},
+		{name: "unknown", fURL: "http://localhost:8080/unknown", wantErr: true},
+		{name: "not_short_link", fURL: "http://localhost:8080/api/user/urls", wantErr: true},
+		{name: "outside_prefix", fURL: "https://sho.rt/direct", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := g.Check(ctx, r, tc.fURL)
+			if tc.wantErr {
+				assert.ErrorAs(t, err, &SelfLinkError{})
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	t.Run("depth", func(t *testing.T) {
+		g, err := NewSelfLinkGuard("http://localhost:8080", nil, SelfLinkResolve, 2, nil)
+		require.NoError(t, err)
+		_, err = g.Check(ctx, r, "https://sho.rt/s/hop2")
+		require.NoError(t, err, "alias host is not configured")
+		_, err = g.Check(ctx, r, "http://localhost:8080/hop1")
+		require.NoError(t, err)
+
+		r["hop3"] = "http://localhost:8080/hop1"
+		_, err = g.Check(ctx, r, "http://localhost:8080/hop3")
+		assert.ErrorAs(t, err, &SelfLinkError{})
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		g, err := NewSelfLinkGuard("http://localhost:8080", nil, SelfLinkReject, 3, nil)
+		require.NoError(t, err)
+		_, err = g.Check(ctx, r, "http://localhost:8080/direct")
+		assert.ErrorAs(t, err, &SelfLinkError{})
+	})
+
+	t.Run("signed", func(t *testing.T) {
+		signer, err := NewLinkSigner(SigningRequired, "k1:secret")
+		require.NoError(t, err)
+		g, err := NewSelfLinkGuard("http://localhost:8080", nil, SelfLinkResolve, 3, signer)
+		require.NoError(t, err)
+
+		got, err := g.Check(ctx, r, models.FullURL("http://localhost:8080/"+signer.Sign("direct")))
+		require.NoError(t, err)
+		assert.Equal(t, models.ShortenID("direct"), got)
+
+		_, err = g.Check(ctx, r, "http://localhost:8080/direct")
+		assert.ErrorAs(t, err, &SelfLinkError{})
+	})
+}
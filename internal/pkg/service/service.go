@@ -1,18 +1,51 @@
 package service
 
 import (
-	"math/rand"
-	"time"
+	"context"
+	"fmt"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
 )
 
 const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
-// GenerateRandChars возвращает строку из случайных символов.
-func GenerateRandChars(n int) []byte {
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
-	shortKey := make([]byte, n)
-	for i := 0; i < n; i++ {
-		shortKey[i] = charset[rnd.Intn(len(charset))]
+// Стратегии генерации коротких идентификаторов
+const (
+	StrategyRandom     = "random"
+	StrategyCounter    = "counter"
+	StrategyHash       = "hash"
+	StrategyObfuscated = "obfuscated"
+)
+
+// IDGenerator генерирует короткие идентификаторы для сокращаемых урлов.
+// attempt — номер попытки, начиная с 0: при коллизии хранилище вызывает Generate
+// повторно с увеличенным attempt, чтобы детерминированные стратегии выдали другой идентификатор
+type IDGenerator interface {
+	Generate(ctx context.Context, fURL models.FullURL, attempt int) (models.ShortenID, error)
+}
+
+// Sequence монотонный счетчик, из которого получают идентификаторы счетные стратегии
+type Sequence interface {
+	Next(ctx context.Context) (uint64, error)
+}
+
+// NewIDGenerator возвращает генератор для стратегии strategy. Для стратегий counter и
+// obfuscated значения счетчика берутся из seq, для obfuscated перемешивание зависит от salt
+func NewIDGenerator(strategy string, length int, salt string, seq Sequence) (IDGenerator, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("id length must be positive, got %d", length)
+	}
+
+	switch strategy {
+	case StrategyRandom:
+		return RandomGenerator{Length: length}, nil
+	case StrategyCounter:
+		return CounterGenerator{Seq: seq, Length: length}, nil
+	case StrategyHash:
+		return HashGenerator{Length: length}, nil
+	case StrategyObfuscated:
+		return NewObfuscatedGenerator(seq, length, salt)
+	default:
+		return nil, fmt.Errorf("unknown id generation strategy %q", strategy)
 	}
-	return shortKey
 }
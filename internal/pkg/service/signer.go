@@ -0,0 +1,128 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+// Режимы подписи коротких ссылок
+const (
+	// SigningOff ссылки не подписываются
+	SigningOff = "off"
+	// SigningOptional новые ссылки подписываются, неподписанные продолжают работать
+	SigningOptional = "optional"
+	// SigningRequired открываются только подписанные ссылки
+	SigningRequired = "required"
+)
+
+const (
+	// signatureLen длина усеченной HMAC подписи в байтах
+	signatureLen = 8
+	// signedLinkSep разделяет ключ, идентификатор и подпись в подписанной ссылке.
+	// Точки нет ни в сгенерированных идентификаторах, ни в alias
+	signedLinkSep = "."
+)
+
+// ErrInvalidSignature подпись короткой ссылки отсутствует или неверна
+var ErrInvalidSignature = errors.New("short link signature is invalid")
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// LinkSigner подписывает короткие ссылки HMAC, чтобы их нельзя было подобрать перебором.
+// Подписанная ссылка имеет вид {key id}.{id}.{подпись}. Подпись проверяется ключом
+// с key id из ссылки, так что при ротации старые ключи оставляют для выданных ссылок.
+// Нулевой *LinkSigner работает как режим off
+type LinkSigner struct {
+	mode string
+	// keyID ключ, которым подписываются новые ссылки
+	keyID string
+	keys  map[string][]byte
+}
+
+// NewLinkSigner инициализирует LinkSigner. keys — пары key_id:secret через запятую,
+// новые ссылки подписываются первым ключом. В режиме off ключи не нужны
+func NewLinkSigner(mode string, keys string) (*LinkSigner, error) {
+	switch mode {
+	case SigningOff:
+		return &LinkSigner{mode: mode}, nil
+	case SigningOptional, SigningRequired:
+	default:
+		return nil, fmt.Errorf("unknown link signing mode %q", mode)
+	}
+
+	s := LinkSigner{
+		mode: mode,
+		keys: make(map[string][]byte),
+	}
+	for _, pair := range strings.Split(keys, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		keyID, secret, ok := strings.Cut(pair, ":")
+		if !ok || !keyIDPattern.MatchString(keyID) || secret == "" {
+			return nil, fmt.Errorf("invalid link signing key %q, expected key_id:secret", keyID)
+		}
+		if _, ok = s.keys[keyID]; ok {
+			return nil, fmt.Errorf("duplicate link signing key %q", keyID)
+		}
+		s.keys[keyID] = []byte(secret)
+		if s.keyID == "" {
+			s.keyID = keyID
+		}
+	}
+	if s.keyID == "" {
+		return nil, errors.New("link signing keys are not set")
+	}
+	return &s, nil
+}
+
+// Sign возвращает сегмент пути короткой ссылки. В режиме off это сам идентификатор
+func (s *LinkSigner) Sign(sID models.ShortenID) string {
+	if s == nil || s.mode == SigningOff {
+		return string(sID)
+	}
+	return s.keyID + signedLinkSep + string(sID) + signedLinkSep + signature(s.keys[s.keyID], s.keyID, sID)
+}
+
+// Verify возвращает идентификатор из сегмента пути короткой ссылки, проверяя подпись
+// без обращения к хранилищу. Неподписанный сегмент допустим только в режимах off
+// и optional, иначе и при неверной подписи возвращается ErrInvalidSignature
+func (s *LinkSigner) Verify(segment string) (models.ShortenID, error) {
+	if s == nil || s.mode == SigningOff {
+		return models.ShortenID(segment), nil
+	}
+
+	keyID, rest, signed := strings.Cut(segment, signedLinkSep)
+	if !signed {
+		if s.mode == SigningOptional {
+			return models.ShortenID(segment), nil
+		}
+		return "", ErrInvalidSignature
+	}
+
+	sID, sig, ok := strings.Cut(rest, signedLinkSep)
+	key, known := s.keys[keyID]
+	if !ok || !known || sID == "" {
+		return "", ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(key, keyID, models.ShortenID(sID)))) {
+		return "", ErrInvalidSignature
+	}
+	return models.ShortenID(sID), nil
+}
+
+// signature возвращает усеченную HMAC-SHA256 подпись идентификатора вместе с key id,
+// чтобы подпись нельзя было перенести на другой ключ
+func signature(key []byte, keyID string, sID models.ShortenID) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(keyID + signedLinkSep + string(sID)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:signatureLen])
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+func TestLinkSigner(t *testing.T) {
+	t.Run("off", func(t *testing.T) {
+		s, err := NewLinkSigner(SigningOff, "")
+		require.NoError(t, err)
+		assert.Equal(t, "abc", s.Sign("abc"))
+
+		sID, err := s.Verify("abc")
+		require.NoError(t, err)
+		assert.Equal(t, models.ShortenID("abc"), sID)
+
+		var nilSigner *LinkSigner
+		assert.Equal(t, "abc", nilSigner.Sign("abc"))
+	})
+
+	t.Run("optional", func(t *testing.T) {
+		s, err := NewLinkSigner(SigningOptional, "k2:new-secret, k1:old-secret")
+		require.NoError(t, err)
+
+		segment := s.Sign("abc")
+		assert.True(t, strings.HasPrefix(segment, "k2.abc."), segment)
+		sID, err := s.Verify(segment)
+		require.NoError(t, err)
+		assert.Equal(t, models.ShortenID("abc"), sID)
+
+		sID, err = s.Verify("abc")
+		require.NoError(t, err)
+		assert.Equal(t, models.ShortenID("abc"), sID, "unsigned links keep working")
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		old, err := NewLinkSigner(SigningRequired, "k1:old-secret")
+		require.NoError(t, err)
+		s, err := NewLinkSigner(SigningRequired, "k2:new-secret,k1:old-secret")
+		require.NoError(t, err)
+
+		sID, err := s.Verify(old.Sign("abc"))
+		require.NoError(t, err)
+		assert.Equal(t, models.ShortenID("abc"), sID)
+
+		dropped, err := NewLinkSigner(SigningRequired, "k2:new-secret")
+		require.NoError(t, err)
+		_, err = dropped.Verify(old.Sign("abc"))
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+	})
+
+	t.Run("required", func(t *testing.T) {
+		s, err := NewLinkSigner(SigningRequired, "k1:secret")
+		require.NoError(t, err)
+		segment := s.Sign("abc")
+		sig := segment[strings.LastIndex(segment, ".")+1:]
+
+		for _, bad := range []string{
+			"abc",
+			"k1.abc",
+			"k1.abd." + sig,
+			"k9.abc." + sig,
+			"k1..",
+			segment + "x",
+		} {
+			_, err = s.Verify(bad)
+			assert.ErrorIs(t, err, ErrInvalidSignature, bad)
+		}
+	})
+
+	t.Run("invalid_config", func(t *testing.T) {
+		for _, tc := range []struct{ mode, keys string }{
+			{"sometimes", "k1:secret"},
+			{SigningRequired, ""},
+			{SigningRequired, "secret"},
+			{SigningRequired, "k.1:secret"},
+			{SigningRequired, "k1:"},
+			{SigningRequired, "k1:a,k1:b"},
+		} {
+			_, err := NewLinkSigner(tc.mode, tc.keys)
+			assert.Error(t, err, tc)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+// maxURLLen максимальная длина урла, совпадает с размером колонки full_url в бд
+const maxURLLen = 2048
+
+// allowedSchemes схемы, урлы с которыми можно сокращать
+var allowedSchemes = map[string]struct{}{
+	"http":  {},
+	"https": {},
+}
+
+// defaultPorts порты по умолчанию, которые удаляются из урла при нормализации
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// URLError урл не прошел проверку
+type URLError struct {
+	URL    string
+	Reason string
+}
+
+func (e URLError) Error() string {
+	return fmt.Sprintf("url '%s' is invalid: %s", e.URL, e.Reason)
+}
+
+// NormalizeURL проверяет урл и приводит его к каноническому виду: обрезает пробельные
+// символы, приводит схему и хост к нижнему регистру, переводит IDN-хост в punycode
+// и удаляет порт по умолчанию. Дедупликация урлов работает по нормализованной форме
+func NormalizeURL(raw string) (models.FullURL, error) {
+	s := strings.TrimSpace(raw)
+	if s == "" {
+		return "", URLError{raw, "url is empty"}
+	}
+	if len(s) > maxURLLen {
+		return "", URLError{s[:64] + "...", fmt.Sprintf("url must be at most %d characters long", maxURLLen)}
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", URLError{s, "url can't be parsed"}
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if _, ok := allowedSchemes[u.Scheme]; !ok {
+		return "", URLError{s, "only http and https urls are allowed"}
+	}
+	if u.Hostname() == "" {
+		return "", URLError{s, "host is required"}
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if net.ParseIP(host) == nil {
+		if host, err = idna.Lookup.ToASCII(host); err != nil {
+			return "", URLError{s, "host is invalid"}
+		}
+	}
+
+	switch port := u.Port(); {
+	case port != "" && port != defaultPorts[u.Scheme]:
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		// IPv6-адрес без порта
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+
+	normalized := u.String()
+	if len(normalized) > maxURLLen {
+		return "", URLError{s[:64] + "...", fmt.Sprintf("url must be at most %d characters long", maxURLLen)}
+	}
+	return models.FullURL(normalized), nil
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	var testCases = []struct {
+		name string
+		raw  string
+		want models.FullURL
+	}{
+		{name: "trims_spaces", raw: "  https://ya.ru/path\n", want: "https://ya.ru/path"},
+		{name: "lowercases_scheme_and_host", raw: "HTTPS://Ya.RU/Path", want: "https://ya.ru/Path"},
+		{name: "removes_default_port", raw: "http://ya.ru:80/a?b=c", want: "http://ya.ru/a?b=c"},
+		{name: "keeps_custom_port", raw: "https://ya.ru:8443/", want: "https://ya.ru:8443/"},
+		{name: "idn_to_punycode", raw: "https://пример.рф/страница", want: "https://xn--e1afmkfd.xn--p1ai/%D1%81%D1%82%D1%80%D0%B0%D0%BD%D0%B8%D1%86%D0%B0"},
+		{name: "ipv6", raw: "http://[::1]:80/", want: "http://[::1]/"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := NormalizeURL(tc.raw)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestNormalizeURLInvalid(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"   ",
+		"javascript:alert(1)",
+		"/relative/path",
+		"ftp://ya.ru/file",
+		"https://",
+		"https://" + strings.Repeat("a", maxURLLen) + ".ru",
+	} {
+		_, err := NormalizeURL(raw)
+		assert.ErrorAs(t, err, &URLError{}, raw)
+	}
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+// maxUTMLen максимальная длина значения UTM метки
+const maxUTMLen = 256
+
+// utmParams пары параметр запроса и значение метки
+func utmParams(utm models.UTM) [][2]string {
+	return [][2]string{
+		{"utm_source", utm.Source},
+		{"utm_medium", utm.Medium},
+		{"utm_campaign", utm.Campaign},
+		{"utm_term", utm.Term},
+		{"utm_content", utm.Content},
+	}
+}
+
+// ValidateUTM проверяет длину значений UTM меток
+func ValidateUTM(utm models.UTM) error {
+	for _, p := range utmParams(utm) {
+		if len(p[1]) > maxUTMLen {
+			return fmt.Errorf("%s must be at most %d characters long", p[0], maxUTMLen)
+		}
+	}
+	return nil
+}
+
+// TagUTM добавляет к урлу параметры utm_* из заданных меток. Параметры, которые уже
+// есть в урле, не перезаписываются, а остальная часть query не меняется
+func TagUTM(fURL models.FullURL, utm models.UTM) (models.FullURL, error) {
+	u, err := url.Parse(string(fURL))
+	if err != nil {
+		return "", err
+	}
+
+	present := u.Query()
+	added := make(url.Values)
+	for _, p := range utmParams(utm) {
+		if p[1] != "" && !present.Has(p[0]) {
+			added.Set(p[0], p[1])
+		}
+	}
+	if len(added) == 0 {
+		return fURL, nil
+	}
+
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += added.Encode()
+	return models.FullURL(u.String()), nil
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+)
+
+func TestTagUTM(t *testing.T) {
+	utm := models.UTM{Source: "mail", Campaign: "spring sale"}
+
+	var testCases = []struct {
+		name string
+		fURL models.FullURL
+		want models.FullURL
+	}{
+		{name: "no_query", fURL: "https://ya.ru/page", want: "https://ya.ru/page?utm_campaign=spring+sale&utm_source=mail"},
+		{name: "keeps_query", fURL: "https://ya.ru/?b=2&a=1#top", want: "https://ya.ru/?b=2&a=1&utm_campaign=spring+sale&utm_source=mail#top"},
+		{name: "no_clobbering", fURL: "https://ya.ru/?utm_source=ads", want: "https://ya.ru/?utm_source=ads&utm_campaign=spring+sale"},
+		{name: "all_present", fURL: "https://ya.ru/?utm_source=&utm_campaign=x", want: "https://ya.ru/?utm_source=&utm_campaign=x"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := TagUTM(tc.fURL, utm)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	assert.Error(t, ValidateUTM(models.UTM{Term: strings.Repeat("a", maxUTMLen+1)}))
+}
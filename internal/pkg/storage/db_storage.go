@@ -4,85 +4,902 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
+	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nartim88/urlshortener/internal/pkg/migrations"
 	"github.com/nartim88/urlshortener/internal/pkg/models"
 	"github.com/nartim88/urlshortener/internal/pkg/service"
 )
 
 type DBStorage struct {
-	conn *pgx.Conn
+	pool *pgxpool.Pool
+	gen  service.IDGenerator
 }
 
-func NewDBStorage(conn *pgx.Conn) StorageWithService {
-	return &DBStorage{conn}
+func NewDBStorage(pool *pgxpool.Pool, gen service.IDGenerator) StorageWithService {
+	return &DBStorage{pool, gen}
 }
 
 func (s DBStorage) Get(ctx context.Context, sID models.ShortenID) (*models.FullURL, error) {
-	var fURL models.FullURL
-	err := s.conn.QueryRow(ctx, `
-		SELECT full_url 
+	target, err := s.Lookup(ctx, sID)
+	if err != nil || target == nil {
+		return nil, err
+	}
+	return &target.FullURL, nil
+}
+
+// Hit засчитывает переход условным UPDATE, так что конкурентные запросы не превысят
+// лимит. Если строка не обновилась, урл без лимита или уже недоступен — это решает Lookup
+func (s DBStorage) Hit(ctx context.Context, sID models.ShortenID) (*models.Target, error) {
+	var row targetRow
+	err := s.pool.QueryRow(ctx, `
+		UPDATE shortener
+		SET clicks = clicks + 1
+		WHERE short_url=$1
+			AND NOT is_deleted
+			AND max_clicks IS NOT NULL AND clicks < max_clicks
+			AND (expires_at IS NULL OR expires_at > now())
+		RETURNING full_url, expires_at, max_clicks, redirect_code, passthrough, utm, password_hash`,
+		sID,
+	).Scan(&row.FullURL, &row.ExpiresAt, &row.MaxClicks, &row.RedirectCode, &row.Passthrough, &row.UTM, &row.PasswordHash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s.Lookup(ctx, sID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while counting url click in the db: %w", err)
+	}
+	return row.target(), nil
+}
+
+// targetRow строка shortener с параметрами урла, допускающими NULL
+type targetRow struct {
+	FullURL      models.FullURL
+	ExpiresAt    *time.Time
+	MaxClicks    *int64
+	RedirectCode *int
+	Passthrough  bool
+	UTM          *models.UTM
+	PasswordHash *string
+}
+
+func (r targetRow) target() *models.Target {
+	t := models.Target{FullURL: r.FullURL}
+	t.ExpiresAt = r.ExpiresAt
+	t.Passthrough = r.Passthrough
+	t.UTM = r.UTM
+	if r.PasswordHash != nil {
+		t.PasswordHash = *r.PasswordHash
+	}
+	if r.MaxClicks != nil {
+		t.MaxClicks = *r.MaxClicks
+	}
+	if r.RedirectCode != nil {
+		t.RedirectCode = *r.RedirectCode
+	}
+	return &t
+}
+
+func (s DBStorage) Lookup(ctx context.Context, sID models.ShortenID) (*models.Target, error) {
+	var row targetRow
+	var isDeleted, isExpired bool
+	err := s.pool.QueryRow(ctx, `
+		SELECT full_url, expires_at, max_clicks, redirect_code, passthrough, utm, password_hash, is_deleted,
+			is_purged OR (expires_at IS NOT NULL AND expires_at <= now())
+				OR (max_clicks IS NOT NULL AND clicks >= max_clicks) AS is_expired
 		FROM shortener 
 		WHERE short_url=$1`,
 		sID,
-	).Scan(&fURL)
+	).Scan(&row.FullURL, &row.ExpiresAt, &row.MaxClicks, &row.RedirectCode, &row.Passthrough, &row.UTM, &row.PasswordHash,
+		&isDeleted, &isExpired,
+	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	return &fURL, nil
+	if isDeleted {
+		return nil, ErrURLDeleted
+	}
+	if isExpired {
+		return nil, ErrURLExpired
+	}
+	return row.target(), nil
+}
+
+// GetEntry берет число переходов из дневных агрегатов статистики, как и GetStats
+func (s DBStorage) GetEntry(ctx context.Context, sID models.ShortenID) (*models.URLEntry, error) {
+	var row targetRow
+	var createdAt *time.Time
+	entry := models.URLEntry{ShortenID: sID}
+	err := s.pool.QueryRow(ctx, `
+		SELECT full_url, expires_at, max_clicks, redirect_code, passthrough, utm, password_hash,
+			COALESCE(user_id::text, ''), created_at, is_deleted,
+			is_purged OR (expires_at IS NOT NULL AND expires_at <= now())
+				OR (max_clicks IS NOT NULL AND clicks >= max_clicks) AS is_expired,
+			(SELECT COALESCE(SUM(d.clicks), 0)::bigint
+				FROM shortener_clicks_daily d
+				WHERE d.short_url = s.short_url) AS total_clicks
+		FROM shortener s
+		WHERE short_url=$1`,
+		sID,
+	).Scan(&row.FullURL, &row.ExpiresAt, &row.MaxClicks, &row.RedirectCode, &row.Passthrough, &row.UTM, &row.PasswordHash,
+		&entry.UserID, &createdAt, &entry.IsDeleted, &entry.IsExpired, &entry.Clicks,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while selecting url entry: %w", err)
+	}
+	entry.Target = *row.target()
+	if createdAt != nil {
+		entry.CreatedAt = *createdAt
+	}
+	return &entry, nil
+}
+
+func (s DBStorage) Set(ctx context.Context, fURL models.FullURL, uID models.UserID, opts models.SetOptions) (*models.ShortenID, error) {
+	if opts.Alias != "" {
+		err := s.insert(ctx, fURL, opts.Alias, uID, opts)
+		if isShortURLConflict(err) {
+			return nil, AliasExistsError{opts.Alias}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &opts.Alias, nil
+	}
+	if opts.Distinct() {
+		return s.setDistinct(ctx, fURL, uID, opts)
+	}
+
+	for attempt := 0; attempt < maxIDAttempts; attempt++ {
+		newSID, err := s.gen.Generate(ctx, fURL, attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		var resSID models.ShortenID
+		var inserted bool
+
+		err = s.pool.QueryRow(ctx, `
+			INSERT INTO shortener (full_url, short_url, user_id)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (full_url) WHERE NOT is_alias AND NOT is_edited AND NOT is_deleted AND expires_at IS NULL
+				AND max_clicks IS NULL AND redirect_code IS NULL AND NOT passthrough AND utm IS NULL AND password_hash IS NULL
+			DO UPDATE
+				SET full_url = EXCLUDED.full_url
+			RETURNING short_url, (xmax = 0) AS inserted;
+			`,
+			fURL, newSID, uID,
+		).Scan(&resSID, &inserted)
+		if isShortURLConflict(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error while trying to save data in the db: %w", err)
+		}
+		if !inserted {
+			err = URLExistsError{
+				fURL,
+				resSID,
+			}
+			return nil, err
+		}
+		return &newSID, nil
+	}
+	return nil, ErrIDCollision
+}
+
+// SetBatch сохраняет пакет в одной транзакции. Вставки отправляются одним pgx.Batch
+// с ON CONFLICT DO NOTHING, чтобы конфликт не прерывал транзакцию. Для не вставленных
+// строк следующим pgx.Batch ищется сохраненный ранее урл, а оставшимся без него
+// идентификатор генерируется заново
+func (s DBStorage) SetBatch(ctx context.Context, uID models.UserID, items []models.BatchItem) ([]models.BatchResult, error) {
+	results := make([]models.BatchResult, len(items))
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	pending := make([]int, len(items))
+	for i := range items {
+		pending[i] = i
+	}
+
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt >= maxIDAttempts {
+			for _, i := range pending {
+				results[i] = models.BatchResult{Err: ErrIDCollision}
+			}
+			break
+		}
+
+		conflicted, err := s.insertBatch(ctx, tx, uID, items, pending, results, attempt)
+		if err != nil {
+			return nil, err
+		}
+		if pending, err = s.resolveConflicts(ctx, tx, items, conflicted, results); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error while committing batch: %w", err)
+	}
+	return results, nil
+}
+
+// insertBatch вставляет урлы items[pending] и возвращает индексы строк, которые
+// не были вставлены из-за конфликта
+func (s DBStorage) insertBatch(
+	ctx context.Context,
+	tx pgx.Tx,
+	uID models.UserID,
+	items []models.BatchItem,
+	pending []int,
+	results []models.BatchResult,
+	attempt int,
+) ([]int, error) {
+	batch := &pgx.Batch{}
+	for _, i := range pending {
+		item := items[i]
+
+		sID := item.Alias
+		if sID == "" {
+			var err error
+			if sID, err = s.gen.Generate(ctx, item.FullURL, attempt); err != nil {
+				return nil, err
+			}
+		}
+		results[i] = models.BatchResult{ShortenID: sID, Created: true}
+
+		row := nullableOptions(item.URLOptions)
+		batch.Queue(`
+			INSERT INTO shortener (
+				full_url, short_url, user_id, is_alias,
+				expires_at, max_clicks, redirect_code, passthrough, utm, password_hash
+			)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			ON CONFLICT DO NOTHING
+			RETURNING short_url`,
+			item.FullURL, sID, uID, item.Alias != "",
+			row.ExpiresAt, row.MaxClicks, row.RedirectCode, row.Passthrough, row.UTM, row.PasswordHash,
+		)
+	}
+
+	br := tx.SendBatch(ctx, batch)
+	var conflicted []int
+	for _, i := range pending {
+		var sID models.ShortenID
+		err := br.QueryRow().Scan(&sID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			conflicted = append(conflicted, i)
+			continue
+		}
+		if err != nil {
+			_ = br.Close()
+			return nil, fmt.Errorf("error while trying to save batch in the db: %w", err)
+		}
+	}
+	if err := br.Close(); err != nil {
+		return nil, fmt.Errorf("error while trying to save batch in the db: %w", err)
+	}
+	return conflicted, nil
 }
 
-func (s DBStorage) Set(ctx context.Context, fURL models.FullURL) (*models.ShortenID, error) {
-	randChars := service.GenerateRandChars(shortURLLen)
-	newSID := models.ShortenID(randChars)
-	var resSID models.ShortenID
+// resolveConflicts для не вставленных строк находит сохраненные ранее урлы и возвращает
+// индексы строк, для которых нужно сгенерировать другой идентификатор. Конфликт строки
+// с alias означает, что alias занят, такая строка получает AliasExistsError
+func (s DBStorage) resolveConflicts(
+	ctx context.Context,
+	tx pgx.Tx,
+	items []models.BatchItem,
+	conflicted []int,
+	results []models.BatchResult,
+) ([]int, error) {
+	var lookup, retry []int
+	for _, i := range conflicted {
+		switch item := items[i]; {
+		case item.Alias != "":
+			results[i] = models.BatchResult{Err: AliasExistsError{item.Alias}}
+		case item.Distinct():
+			retry = append(retry, i)
+		default:
+			lookup = append(lookup, i)
+		}
+	}
+	if len(lookup) == 0 {
+		return retry, nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, i := range lookup {
+		batch.Queue(`
+			SELECT short_url
+			FROM shortener
+			WHERE full_url=$1 AND NOT is_alias AND NOT is_edited AND NOT is_deleted
+				AND expires_at IS NULL AND max_clicks IS NULL AND redirect_code IS NULL
+				AND NOT passthrough AND utm IS NULL AND password_hash IS NULL`,
+			items[i].FullURL,
+		)
+	}
 
-	err := s.conn.QueryRow(ctx, `
-		INSERT INTO shortener (full_url, short_url)
-		VALUES ($1, $2)
-		ON CONFLICT (full_url) DO UPDATE
-			SET full_url = EXCLUDED.full_url
-		RETURNING short_url;
-		`,
-		fURL, newSID,
-	).Scan(&resSID)
+	br := tx.SendBatch(ctx, batch)
+	for _, i := range lookup {
+		var sID models.ShortenID
+		err := br.QueryRow().Scan(&sID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			retry = append(retry, i)
+			continue
+		}
+		if err != nil {
+			_ = br.Close()
+			return nil, fmt.Errorf("error while selecting saved urls: %w", err)
+		}
+		results[i] = models.BatchResult{ShortenID: sID}
+	}
+	if err := br.Close(); err != nil {
+		return nil, fmt.Errorf("error while selecting saved urls: %w", err)
+	}
+	return retry, nil
+}
+
+// GetByFullURL повторяет условие уникального индекса по full_url, чтобы запрос шел по нему
+func (s DBStorage) GetByFullURL(ctx context.Context, fURL models.FullURL) (*models.ShortenID, error) {
+	var sID models.ShortenID
+	err := s.pool.QueryRow(ctx, `
+		SELECT short_url
+		FROM shortener
+		WHERE full_url=$1 AND NOT is_alias AND NOT is_edited AND NOT is_deleted
+			AND expires_at IS NULL AND max_clicks IS NULL AND redirect_code IS NULL
+			AND NOT passthrough AND utm IS NULL AND password_hash IS NULL`,
+		fURL,
+	).Scan(&sID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error while trying to save data in the db: %w", err)
+		return nil, fmt.Errorf("error while selecting url by full url: %w", err)
 	}
-	if newSID != resSID {
-		err = URLExistsError{
-			fURL,
-			resSID,
+	return &sID, nil
+}
+
+func (s DBStorage) GetUserURLs(ctx context.Context, uID models.UserID) ([]models.UserURL, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT short_url, full_url
+		FROM shortener
+		WHERE user_id=$1 AND NOT is_deleted AND NOT is_purged`,
+		uID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error while selecting user urls: %w", err)
+	}
+	defer rows.Close()
+
+	var urls []models.UserURL
+	for rows.Next() {
+		var u models.UserURL
+		if err = rows.Scan(&u.ShortenID, &u.FullURL); err != nil {
+			return nil, err
 		}
+		urls = append(urls, u)
+	}
+	if err = rows.Err(); err != nil {
 		return nil, err
 	}
-	return &newSID, nil
+	return urls, nil
+}
+
+func (s DBStorage) DeleteURLs(ctx context.Context, tasks []models.DeleteTask) error {
+	sIDs := make([]string, 0, len(tasks))
+	uIDs := make([]string, 0, len(tasks))
+	for _, t := range tasks {
+		sIDs = append(sIDs, string(t.ShortenID))
+		uIDs = append(uIDs, string(t.UserID))
+	}
+
+	_, err := s.pool.Exec(ctx, `
+		UPDATE shortener
+		SET is_deleted = TRUE
+		FROM unnest($1::text[], $2::uuid[]) AS d(short_url, user_id)
+		WHERE shortener.short_url = d.short_url AND shortener.user_id = d.user_id`,
+		sIDs, uIDs,
+	)
+	if err != nil {
+		return fmt.Errorf("error while deleting urls in the db: %w", err)
+	}
+	return nil
+}
+
+func (s DBStorage) Ping(ctx context.Context) error {
+	return s.pool.Ping(ctx)
 }
 
 func (s DBStorage) Close(ctx context.Context) error {
-	if s.conn == nil {
-		return errors.New("db connection doesn't exists or already closed")
+	if s.pool == nil {
+		return errors.New("db pool doesn't exists or already closed")
 	}
-	if err := s.conn.Close(ctx); err != nil {
-		return err
+	s.pool.Close()
+	return nil
+}
+
+// PurgeExpired оставляет от урлов с истекшим сроком жизни или исчерпанным лимитом переходов
+// надгробия: целевой урл, статистика и история удаляются в одной транзакции, а строка
+// остается, чтобы идентификатор не был выдан заново. Срок жизни и лимит в строке
+// сохраняются, так что надгробие не попадает в уникальный индекс по full_url
+func (s DBStorage) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	rows, err := tx.Query(ctx, `
+		UPDATE shortener
+		SET is_purged=TRUE, full_url='', utm=NULL, password_hash=NULL
+		WHERE NOT is_purged
+			AND ((expires_at IS NOT NULL AND expires_at <= $1)
+				OR (max_clicks IS NOT NULL AND clicks >= max_clicks))
+		RETURNING short_url`,
+		now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error while purging expired urls in the db: %w", err)
+	}
+	var sIDs []string
+	for rows.Next() {
+		var sID string
+		if err = rows.Scan(&sID); err != nil {
+			return 0, err
+		}
+		sIDs = append(sIDs, sID)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("error while purging expired urls in the db: %w", err)
+	}
+	if len(sIDs) == 0 {
+		return 0, nil
+	}
+
+	for _, query := range []string{
+		`DELETE FROM shortener_clicks_daily WHERE short_url = ANY($1)`,
+		`DELETE FROM shortener_clicks_referrers WHERE short_url = ANY($1)`,
+		`DELETE FROM shortener_visitors WHERE short_url = ANY($1)`,
+		`DELETE FROM shortener_history WHERE short_url = ANY($1)`,
+	} {
+		if _, err = tx.Exec(ctx, query, sIDs); err != nil {
+			return 0, fmt.Errorf("error while purging stats and history in the db: %w", err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("error while committing purge: %w", err)
+	}
+	return len(sIDs), nil
+}
+
+// SaveClicks агрегирует события и одной транзакцией добавляет их в счетчики по дням,
+// по источникам и в множество посетителей. События по урлам, которых нет в бд или которые
+// очищены PurgeExpired, отбрасываются
+func (s DBStorage) SaveClicks(ctx context.Context, events []models.ClickEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	type dayKey struct {
+		sID models.ShortenID
+		day string
+	}
+	type referrerKey struct {
+		sID      models.ShortenID
+		referrer string
+	}
+	type visitorKey struct {
+		sID    models.ShortenID
+		ipHash string
+	}
+
+	byDay := make(map[dayKey]int64)
+	byReferrer := make(map[referrerKey]int64)
+	visitors := make(map[visitorKey]struct{})
+	for _, e := range events {
+		byDay[dayKey{e.ShortenID, e.Time.UTC().Format(statsDayLayout)}]++
+		byReferrer[referrerKey{e.ShortenID, referrerKeyOf(e.Referrer)}]++
+		if e.IPHash != "" {
+			visitors[visitorKey{e.ShortenID, e.IPHash}] = struct{}{}
+		}
+	}
+
+	var daySIDs, days []string
+	var dayClicks []int64
+	for k, v := range byDay {
+		daySIDs = append(daySIDs, string(k.sID))
+		days = append(days, k.day)
+		dayClicks = append(dayClicks, v)
+	}
+
+	var refSIDs, referrers []string
+	var refClicks []int64
+	for k, v := range byReferrer {
+		refSIDs = append(refSIDs, string(k.sID))
+		referrers = append(referrers, k.referrer)
+		refClicks = append(refClicks, v)
+	}
+
+	var visitorSIDs, ipHashes []string
+	for k := range visitors {
+		visitorSIDs = append(visitorSIDs, string(k.sID))
+		ipHashes = append(ipHashes, k.ipHash)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO shortener_clicks_daily (short_url, day, clicks)
+		SELECT d.short_url, d.day::date, d.clicks
+		FROM unnest($1::text[], $2::text[], $3::bigint[]) AS d(short_url, day, clicks)
+		JOIN shortener ON shortener.short_url = d.short_url AND NOT shortener.is_purged
+		ON CONFLICT (short_url, day) DO UPDATE
+			SET clicks = shortener_clicks_daily.clicks + EXCLUDED.clicks`,
+		daySIDs, days, dayClicks,
+	)
+	if err != nil {
+		return fmt.Errorf("error while saving daily clicks in the db: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO shortener_clicks_referrers (short_url, referrer, clicks)
+		SELECT r.short_url, r.referrer, r.clicks
+		FROM unnest($1::text[], $2::text[], $3::bigint[]) AS r(short_url, referrer, clicks)
+		JOIN shortener ON shortener.short_url = r.short_url AND NOT shortener.is_purged
+		ON CONFLICT (short_url, referrer) DO UPDATE
+			SET clicks = shortener_clicks_referrers.clicks + EXCLUDED.clicks`,
+		refSIDs, referrers, refClicks,
+	)
+	if err != nil {
+		return fmt.Errorf("error while saving referrer clicks in the db: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO shortener_visitors (short_url, ip_hash)
+		SELECT v.short_url, v.ip_hash
+		FROM unnest($1::text[], $2::text[]) AS v(short_url, ip_hash)
+		JOIN shortener ON shortener.short_url = v.short_url AND NOT shortener.is_purged
+		ON CONFLICT DO NOTHING`,
+		visitorSIDs, ipHashes,
+	)
+	if err != nil {
+		return fmt.Errorf("error while saving visitors in the db: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s DBStorage) GetStats(ctx context.Context, sID models.ShortenID) (*models.URLStats, error) {
+	var exists bool
+	err := s.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM shortener WHERE short_url=$1)`, sID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("error while selecting url: %w", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	stats := emptyStats()
+
+	err = s.pool.QueryRow(ctx, `SELECT count(*) FROM shortener_visitors WHERE short_url=$1`, sID).
+		Scan(&stats.UniqueVisitors)
+	if err != nil {
+		return nil, fmt.Errorf("error while counting visitors: %w", err)
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT to_char(day, 'YYYY-MM-DD'), clicks
+		FROM shortener_clicks_daily
+		WHERE short_url=$1`,
+		sID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error while selecting daily clicks: %w", err)
+	}
+	if err = scanCounters(rows, stats.ByDay); err != nil {
+		return nil, err
+	}
+	for _, n := range stats.ByDay {
+		stats.TotalClicks += n
+	}
+
+	rows, err = s.pool.Query(ctx, `
+		SELECT referrer, clicks
+		FROM shortener_clicks_referrers
+		WHERE short_url=$1`,
+		sID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error while selecting referrer clicks: %w", err)
+	}
+	if err = scanCounters(rows, stats.ByReferrer); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func (s DBStorage) GetUTMDefaults(ctx context.Context, uID models.UserID) (*models.UTM, error) {
+	var utm models.UTM
+	err := s.pool.QueryRow(ctx, `SELECT utm FROM shortener_utm_defaults WHERE user_id=$1`, uID).Scan(&utm)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while selecting utm defaults: %w", err)
+	}
+	return &utm, nil
+}
+
+func (s DBStorage) SetUTMDefaults(ctx context.Context, uID models.UserID, utm *models.UTM) error {
+	var err error
+	if utm == nil || utm.IsZero() {
+		_, err = s.pool.Exec(ctx, `DELETE FROM shortener_utm_defaults WHERE user_id=$1`, uID)
+	} else {
+		_, err = s.pool.Exec(ctx, `
+			INSERT INTO shortener_utm_defaults (user_id, utm)
+			VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET utm = EXCLUDED.utm`,
+			uID, utm,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("error while saving utm defaults in the db: %w", err)
 	}
 	return nil
 }
 
-func (s DBStorage) Bootstrap(ctx context.Context) (err error) {
-	_, err = s.conn.Exec(ctx, `
-		CREATE TABLE IF NOT EXISTS shortener (
-		    id uuid DEFAULT gen_random_uuid() PRIMARY KEY,
-		    full_url VARCHAR(2048) NOT NULL CHECK (full_url <> ''),
-		    short_url VARCHAR(8) NOT NULL CHECK (short_url <> ''),
-		    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-		);
-		CREATE INDEX IF NOT EXISTS shortener_short_url_idx ON shortener (short_url);
-		CREATE UNIQUE INDEX IF NOT EXISTS shortener_full_url_unique_idx ON shortener (full_url)
-		`,
+// UpdateURL меняет строку урла и добавляет изменение в историю в одной транзакции.
+// Строка блокируется на время изменения, так что конкурентные изменения одного урла
+// выполняются по очереди. Измененная строка помечается is_edited и выпадает из
+// уникального индекса по full_url: иначе изменение могло бы нарушить уникальность,
+// а новый урл дедуплицировался бы в ссылку, цель которой владелец может снова поменять
+func (s DBStorage) UpdateURL(
+	ctx context.Context, uID models.UserID, sID models.ShortenID, update func(*models.Target) error,
+) (*models.URLChange, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	var row targetRow
+	var isOwner, isDeleted, isPurged bool
+	err = tx.QueryRow(ctx, `
+		SELECT full_url, expires_at, max_clicks, redirect_code, passthrough, utm, password_hash,
+			COALESCE(user_id = $2, FALSE) AS is_owner, is_deleted, is_purged
+		FROM shortener
+		WHERE short_url=$1
+		FOR UPDATE`,
+		sID, uID,
+	).Scan(&row.FullURL, &row.ExpiresAt, &row.MaxClicks, &row.RedirectCode, &row.Passthrough, &row.UTM, &row.PasswordHash,
+		&isOwner, &isDeleted, &isPurged,
 	)
-	return
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrURLNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while selecting url for update: %w", err)
+	}
+	if !isOwner {
+		return nil, ErrNotOwner
+	}
+	if isDeleted {
+		return nil, ErrURLDeleted
+	}
+	if isPurged {
+		return nil, ErrURLExpired
+	}
+
+	change := models.URLChange{
+		UserID:    uID,
+		ChangedAt: time.Now(),
+		Old:       *row.target(),
+	}
+	change.New = change.Old.Clone()
+	if err := update(&change.New); err != nil {
+		return nil, err
+	}
+	if change.New.Equal(change.Old) {
+		return nil, ErrURLNotModified
+	}
+
+	newRow := nullableOptions(change.New.URLOptions)
+	_, err = tx.Exec(ctx, `
+		UPDATE shortener
+		SET full_url=$2, expires_at=$3, max_clicks=$4, redirect_code=$5, passthrough=$6, utm=$7,
+			password_hash=$8, is_edited=TRUE
+		WHERE short_url=$1`,
+		sID, change.New.FullURL,
+		newRow.ExpiresAt, newRow.MaxClicks, newRow.RedirectCode, newRow.Passthrough, newRow.UTM, newRow.PasswordHash,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error while updating url in the db: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO shortener_history (short_url, user_id, changed_at, old_target, new_target)
+		VALUES ($1, $2, $3, $4, $5)`,
+		sID, uID, change.ChangedAt, change.Old, change.New,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error while saving url change in the db: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error while committing url change: %w", err)
+	}
+	return &change, nil
+}
+
+func (s DBStorage) GetURLHistory(ctx context.Context, uID models.UserID, sID models.ShortenID) ([]models.URLChange, error) {
+	var isOwner bool
+	err := s.pool.QueryRow(ctx, `
+		SELECT COALESCE(user_id = $2, FALSE) FROM shortener WHERE short_url=$1`,
+		sID, uID,
+	).Scan(&isOwner)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrURLNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while selecting url owner: %w", err)
+	}
+	if !isOwner {
+		return nil, ErrNotOwner
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT user_id::text, changed_at, old_target, new_target
+		FROM shortener_history
+		WHERE short_url=$1
+		ORDER BY id`,
+		sID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error while selecting url history: %w", err)
+	}
+	defer rows.Close()
+
+	var history []models.URLChange
+	for rows.Next() {
+		var change models.URLChange
+		if err = rows.Scan(&change.UserID, &change.ChangedAt, &change.Old, &change.New); err != nil {
+			return nil, fmt.Errorf("error while scanning url history: %w", err)
+		}
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
+
+// scanCounters читает строки вида (ключ, счетчик) в dst и закрывает rows
+func scanCounters(rows pgx.Rows, dst map[string]int64) error {
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var n int64
+		if err := rows.Scan(&key, &n); err != nil {
+			return err
+		}
+		dst[key] = n
+	}
+	return rows.Err()
+}
+
+// setDistinct сохраняет урл с параметрами, исключающими дедупликацию, под
+// сгенерированным идентификатором. Такие записи не участвуют в дедупликации по full_url
+func (s DBStorage) setDistinct(ctx context.Context, fURL models.FullURL, uID models.UserID, opts models.SetOptions) (*models.ShortenID, error) {
+	for attempt := 0; attempt < maxIDAttempts; attempt++ {
+		sID, err := s.gen.Generate(ctx, fURL, attempt)
+		if err != nil {
+			return nil, err
+		}
+		err = s.insert(ctx, fURL, sID, uID, opts)
+		if isShortURLConflict(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &sID, nil
+	}
+	return nil, ErrIDCollision
+}
+
+// insert сохраняет урл под идентификатором sID без дедупликации по full_url
+func (s DBStorage) insert(ctx context.Context, fURL models.FullURL, sID models.ShortenID, uID models.UserID, opts models.SetOptions) error {
+	row := nullableOptions(opts.URLOptions)
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO shortener (
+			full_url, short_url, user_id, is_alias,
+			expires_at, max_clicks, redirect_code, passthrough, utm, password_hash
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		fURL, sID, uID, opts.Alias != "",
+		row.ExpiresAt, row.MaxClicks, row.RedirectCode, row.Passthrough, row.UTM, row.PasswordHash,
+	)
+	if err != nil {
+		return fmt.Errorf("error while trying to save data in the db: %w", err)
+	}
+	return nil
+}
+
+// nullableOptions возвращает параметры урла для записи в бд, незаданные параметры — как NULL
+func nullableOptions(opts models.URLOptions) targetRow {
+	row := targetRow{
+		ExpiresAt:   opts.ExpiresAt,
+		Passthrough: opts.Passthrough,
+		UTM:         opts.UTM,
+	}
+	if opts.MaxClicks > 0 {
+		row.MaxClicks = &opts.MaxClicks
+	}
+	if opts.RedirectCode != 0 {
+		row.RedirectCode = &opts.RedirectCode
+	}
+	if opts.PasswordHash != "" {
+		row.PasswordHash = &opts.PasswordHash
+	}
+	return row
+}
+
+// isShortURLConflict проверяет, что запись не удалась из-за уже занятого короткого идентификатора
+func isShortURLConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) &&
+		pgErr.Code == pgerrcode.UniqueViolation &&
+		pgErr.ConstraintName == "shortener_short_url_unique_idx"
+}
+
+// DBSequence счетчик для генерации идентификаторов на основе последовательности в бд,
+// общий для всех реплик сервиса
+type DBSequence struct {
+	pool *pgxpool.Pool
+}
+
+func NewDBSequence(pool *pgxpool.Pool) *DBSequence {
+	return &DBSequence{pool}
+}
+
+func (s *DBSequence) Next(ctx context.Context) (uint64, error) {
+	var n int64
+	if err := s.pool.QueryRow(ctx, `SELECT nextval('shortener_short_url_seq')`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("error while getting next sequence value: %w", err)
+	}
+	return uint64(n), nil
+}
+
+// Bootstrap применяет к бд все не примененные миграции
+func (s DBStorage) Bootstrap(ctx context.Context) error {
+	m, err := migrations.New(s.pool)
+	if err != nil {
+		return err
+	}
+	return m.Up(ctx)
 }
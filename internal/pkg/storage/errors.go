@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/nartim88/urlshortener/internal/pkg/models"
@@ -15,3 +16,30 @@ type URLExistsError struct {
 func (u URLExistsError) Error() string {
 	return fmt.Sprintf("'%s' is already saved", u.OriginalURL)
 }
+
+// AliasExistsError пользовательский короткий идентификатор уже занят
+type AliasExistsError struct {
+	Alias models.ShortenID
+}
+
+func (a AliasExistsError) Error() string {
+	return fmt.Sprintf("alias '%s' is already taken", a.Alias)
+}
+
+// ErrURLDeleted урл удален пользователем
+var ErrURLDeleted = errors.New("url is deleted")
+
+// ErrURLExpired истек срок жизни урла или исчерпан лимит переходов
+var ErrURLExpired = errors.New("url is expired")
+
+// ErrURLNotFound урла нет в хранилище
+var ErrURLNotFound = errors.New("url is not found")
+
+// ErrURLNotModified изменение не меняет цель урла
+var ErrURLNotModified = errors.New("url is not modified")
+
+// ErrNotOwner урл принадлежит другому пользователю
+var ErrNotOwner = errors.New("url belongs to another user")
+
+// ErrIDCollision не удалось сгенерировать свободный короткий идентификатор
+var ErrIDCollision = errors.New("failed to generate unique short url")
@@ -5,7 +5,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/nartim88/urlshortener/internal/pkg/logger"
@@ -13,126 +19,836 @@ import (
 	"github.com/nartim88/urlshortener/internal/pkg/service"
 )
 
+// SyncPolicy политика сброса записанных в файл данных на диск
+type SyncPolicy string
+
+const (
+	// SyncAlways fsync после каждой записи
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval fsync с заданным периодом, если были записи
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever сброс на диск остается на усмотрение ОС
+	SyncNever SyncPolicy = "never"
+)
+
+// FileStorage хранилище с журналом записей в файле. При старте журнал один раз
+// вычитывается в индекс в памяти, новые записи дописываются в конец файла
 type FileStorage struct {
 	// FilePath абсолютный путь к файлу для хранения данных
-	FilePath string
-	FilePerm os.FileMode
+	FilePath     string
+	FilePerm     os.FileMode
+	SyncPolicy   SyncPolicy
+	SyncInterval time.Duration
+
+	gen     service.IDGenerator
+	mu      sync.RWMutex
+	file    *os.File
+	dirty   bool
+	entries map[models.ShortenID]models.FileJSONEntry
+	byURL   map[models.FullURL]models.ShortenID
+	stats   map[models.ShortenID]*clickStats
+	// utmDefaults UTM метки пользователей по умолчанию
+	utmDefaults map[models.UserID]models.UTM
+	// history изменения урлов владельцами
+	history map[models.ShortenID][]models.URLChange
+	// issued число записей урлов, когда-либо добавленных в журнал. Не меньше числа
+	// значений, выданных счетчиком генератора
+	issued uint64
+
+	stop chan struct{}
+	done chan struct{}
 }
 
 // NewFileStorage инициализация конкретного Storage
-func NewFileStorage(path string) (Storage, error) {
-	s := FileStorage{path, 0666}
-	if !s.fileExists() {
-		err := s.createFile()
-		if err != nil {
-			return nil, err
+func NewFileStorage(path string, policy SyncPolicy, interval time.Duration, gen service.IDGenerator) (StorageWithService, error) {
+	switch policy {
+	case SyncAlways, SyncNever:
+	case SyncInterval:
+		if interval <= 0 {
+			return nil, fmt.Errorf("sync interval must be positive, got %s", interval)
 		}
+	default:
+		return nil, fmt.Errorf("unknown sync policy %q", policy)
+	}
+
+	s := FileStorage{
+		FilePath:     path,
+		FilePerm:     0666,
+		SyncPolicy:   policy,
+		SyncInterval: interval,
+		gen:          gen,
+		entries:      make(map[models.ShortenID]models.FileJSONEntry),
+		byURL:        make(map[models.FullURL]models.ShortenID),
+		stats:        make(map[models.ShortenID]*clickStats),
+		utmDefaults:  make(map[models.UserID]models.UTM),
+		history:      make(map[models.ShortenID][]models.URLChange),
 	}
 	return &s, nil
 }
 
 func (s *FileStorage) Get(ctx context.Context, sID models.ShortenID) (*models.FullURL, error) {
-	entry, err := s.getByShortURL(sID)
-	if err != nil {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[sID]
+	if !ok {
+		return nil, nil
+	}
+	if err := resolveFileEntry(entry, time.Now()); err != nil {
 		return nil, err
 	}
-	if entry == nil {
+	return &entry.FullURL, nil
+}
+
+func (s *FileStorage) Lookup(ctx context.Context, sID models.ShortenID) (*models.Target, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[sID]
+	if !ok {
 		return nil, nil
 	}
-	return &entry.FullURL, nil
+	if err := resolveFileEntry(entry, time.Now()); err != nil {
+		return nil, err
+	}
+	return &models.Target{FullURL: entry.FullURL, URLOptions: entry.URLOptions}, nil
 }
 
-func (s *FileStorage) Set(ctx context.Context, fURL models.FullURL) (*models.ShortenID, error) {
-	randChars := service.GenerateRandChars(shortURLLen)
-	sID := models.ShortenID(randChars)
+func (s *FileStorage) GetEntry(ctx context.Context, sID models.ShortenID) (*models.URLEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	_, err := s.getByShortURL(sID)
-	if err != nil {
+	entry, ok := s.entries[sID]
+	if !ok {
+		return nil, nil
+	}
+
+	urlEntry := models.URLEntry{
+		ShortenID: sID,
+		UserID:    entry.UserID,
+		Target:    models.Target{FullURL: entry.FullURL, URLOptions: entry.URLOptions},
+		IsDeleted: entry.IsDeleted,
+		IsExpired: entry.IsPurged || entry.Expired(time.Now(), entry.Clicks),
+	}
+	if entry.CreatedAt != nil {
+		urlEntry.CreatedAt = *entry.CreatedAt
+	}
+	if stats, ok := s.stats[sID]; ok {
+		urlEntry.Clicks = stats.total
+	}
+	return &urlEntry, nil
+}
+
+func (s *FileStorage) Hit(ctx context.Context, sID models.ShortenID) (*models.Target, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[sID]
+	if !ok {
+		return nil, nil
+	}
+	if err := resolveFileEntry(entry, time.Now()); err != nil {
 		return nil, err
 	}
 
+	// переходы учитываются в журнале только для урлов с лимитом
+	if entry.MaxClicks > 0 {
+		newUUID, err := uuid.NewUUID()
+		if err != nil {
+			return nil, err
+		}
+		hit := models.FileJSONEntry{
+			ID:        &newUUID,
+			ShortenID: sID,
+			IsHit:     true,
+		}
+		if err = s.saveToFile(hit); err != nil {
+			return nil, err
+		}
+		s.apply(hit)
+	}
+	return &models.Target{FullURL: entry.FullURL, URLOptions: entry.URLOptions}, nil
+}
+
+func (s *FileStorage) Set(ctx context.Context, fURL models.FullURL, uID models.UserID, opts models.SetOptions) (*models.ShortenID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sID := opts.Alias
+	if sID != "" {
+		if _, ok := s.entries[sID]; ok {
+			return nil, AliasExistsError{sID}
+		}
+	} else {
+		if existing, ok := s.byURL[fURL]; ok && !opts.Distinct() {
+			return nil, URLExistsError{fURL, existing}
+		}
+
+		var err error
+		sID, err = s.newShortenID(ctx, fURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	newUUID, err := uuid.NewUUID()
 	if err != nil {
 		return nil, err
 	}
 
+	now := time.Now()
 	newEntry := models.FileJSONEntry{
-		ID:        &newUUID,
-		ShortenID: sID,
-		FullURL:   fURL,
+		ID:         &newUUID,
+		ShortenID:  sID,
+		FullURL:    fURL,
+		UserID:     uID,
+		IsAlias:    opts.Alias != "",
+		CreatedAt:  &now,
+		URLOptions: opts.URLOptions,
 	}
 
 	if err = s.saveToFile(newEntry); err != nil {
 		return nil, err
 	}
+	s.apply(newEntry)
 
 	return &sID, nil
 }
 
-// getByShortURL возвращает полный урл по короткому
-func (s *FileStorage) getByShortURL(sID models.ShortenID) (*models.FileJSONEntry, error) {
-	var entry models.FileJSONEntry
+// SetBatch дописывает все записи пакета в журнал одной операцией записи
+func (s *FileStorage) SetBatch(ctx context.Context, uID models.UserID, items []models.BatchItem) ([]models.BatchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	scanner, err := s.newScanner()
-	if err != nil {
-		return nil, err
+	results := make([]models.BatchResult, len(items))
+	records := make([]models.FileJSONEntry, 0, len(items))
+	pending := make(map[models.ShortenID]struct{}, len(items))
+	pendingURLs := make(map[models.FullURL]models.ShortenID)
+
+	taken := func(sID models.ShortenID) bool {
+		_, inStore := s.entries[sID]
+		_, inBatch := pending[sID]
+		return inStore || inBatch
 	}
 
-	for scanner.Scan() {
-		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+	now := time.Now()
+	for i, item := range items {
+		sID := item.Alias
+		switch {
+		case sID != "":
+			if taken(sID) {
+				results[i].Err = AliasExistsError{sID}
+				continue
+			}
+		case !item.Distinct():
+			if existing, ok := s.byURL[item.FullURL]; ok {
+				results[i] = models.BatchResult{ShortenID: existing}
+				continue
+			}
+			if existing, ok := pendingURLs[item.FullURL]; ok {
+				results[i] = models.BatchResult{ShortenID: existing}
+				continue
+			}
+			fallthrough
+		default:
+			var err error
+			sID, err = s.newFreeShortenID(ctx, item.FullURL, taken)
+			if errors.Is(err, ErrIDCollision) {
+				results[i].Err = err
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !item.Distinct() {
+				pendingURLs[item.FullURL] = sID
+			}
+		}
+
+		newUUID, err := uuid.NewUUID()
+		if err != nil {
 			return nil, err
 		}
-		if entry.ShortenID == sID {
-			return &entry, nil
+		records = append(records, models.FileJSONEntry{
+			ID:         &newUUID,
+			ShortenID:  sID,
+			FullURL:    item.FullURL,
+			UserID:     uID,
+			IsAlias:    item.Alias != "",
+			CreatedAt:  &now,
+			URLOptions: item.URLOptions,
+		})
+		pending[sID] = struct{}{}
+		results[i] = models.BatchResult{ShortenID: sID, Created: true}
+	}
+
+	if err := s.saveToFile(records...); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		s.apply(record)
+	}
+	return results, nil
+}
+
+func (s *FileStorage) GetByFullURL(ctx context.Context, fURL models.FullURL) (*models.ShortenID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sID, ok := s.byURL[fURL]
+	if !ok {
+		return nil, nil
+	}
+	if entry, ok := s.entries[sID]; !ok || entry.IsDeleted {
+		return nil, nil
+	}
+	return &sID, nil
+}
+
+func (s *FileStorage) GetUserURLs(ctx context.Context, uID models.UserID) ([]models.UserURL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var urls []models.UserURL
+	for _, entry := range s.entries {
+		if entry.UserID == uID && !entry.IsDeleted && !entry.IsPurged {
+			urls = append(urls, models.UserURL{ShortenID: entry.ShortenID, FullURL: entry.FullURL})
+		}
+	}
+
+	return urls, nil
+}
+
+func (s *FileStorage) DeleteURLs(ctx context.Context, tasks []models.DeleteTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range tasks {
+		entry, ok := s.entries[t.ShortenID]
+		if !ok || entry.UserID != t.UserID || entry.IsDeleted {
+			continue
 		}
+
+		newUUID, err := uuid.NewUUID()
+		if err != nil {
+			return err
+		}
+
+		tombstone := models.FileJSONEntry{
+			ID:        &newUUID,
+			ShortenID: t.ShortenID,
+			UserID:    t.UserID,
+			IsDeleted: true,
+		}
+		if err = s.saveToFile(tombstone); err != nil {
+			return err
+		}
+		s.apply(tombstone)
+	}
+
+	return nil
+}
+
+// PurgeExpired дописывает в журнал записи об очистке истекших урлов, так что и после
+// перезапуска от них остаются только надгробия, занимающие идентификатор
+func (s *FileStorage) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []models.FileJSONEntry
+	for sID, entry := range s.entries {
+		if entry.IsPurged || !entry.Expired(now, entry.Clicks) {
+			continue
+		}
+		newUUID, err := uuid.NewUUID()
+		if err != nil {
+			return 0, err
+		}
+		records = append(records, models.FileJSONEntry{
+			ID:        &newUUID,
+			ShortenID: sID,
+			IsPurged:  true,
+		})
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	if err := s.saveToFile(records...); err != nil {
+		return 0, err
+	}
+	for _, record := range records {
+		s.apply(record)
+	}
+	return len(records), nil
+}
+
+// SaveClicks дописывает в журнал не сами события, а прирост агрегатов по каждому урлу
+// пакета, одной операцией записи
+func (s *FileStorage) SaveClicks(ctx context.Context, events []models.ClickEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deltas := make(map[models.ShortenID]*models.ClickStatsDelta)
+	seen := make(map[models.ShortenID]map[string]struct{})
+	var order []models.ShortenID
+	for _, e := range events {
+		if entry, ok := s.entries[e.ShortenID]; !ok || entry.IsPurged {
+			continue
+		}
+		delta, ok := deltas[e.ShortenID]
+		if !ok {
+			delta = &models.ClickStatsDelta{
+				ByDay:      make(map[string]int64),
+				ByReferrer: make(map[string]int64),
+			}
+			deltas[e.ShortenID] = delta
+			seen[e.ShortenID] = make(map[string]struct{})
+			order = append(order, e.ShortenID)
+		}
+		stats, ok := s.stats[e.ShortenID]
+		if !ok {
+			stats = newClickStats()
+		}
+		stats.addToDelta(delta, seen[e.ShortenID], e)
+	}
+	if len(order) == 0 {
+		return nil
+	}
+
+	records := make([]models.FileJSONEntry, 0, len(order))
+	for _, sID := range order {
+		newUUID, err := uuid.NewUUID()
+		if err != nil {
+			return err
+		}
+		records = append(records, models.FileJSONEntry{
+			ID:        &newUUID,
+			ShortenID: sID,
+			Stats:     deltas[sID],
+		})
+	}
+
+	if err := s.saveToFile(records...); err != nil {
+		return err
+	}
+	for _, record := range records {
+		s.apply(record)
+	}
+	return nil
+}
+
+func (s *FileStorage) GetUTMDefaults(ctx context.Context, uID models.UserID) (*models.UTM, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	utm, ok := s.utmDefaults[uID]
+	if !ok {
+		return nil, nil
 	}
+	return &utm, nil
+}
+
+func (s *FileStorage) SetUTMDefaults(ctx context.Context, uID models.UserID, utm *models.UTM) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	return nil, nil
+	newUUID, err := uuid.NewUUID()
+	if err != nil {
+		return err
+	}
+
+	// пустые метки в журнале означают сброс значений по умолчанию
+	record := models.FileJSONEntry{
+		ID:          &newUUID,
+		UserID:      uID,
+		UTMDefaults: &models.UTM{},
+	}
+	if utm != nil {
+		record.UTMDefaults = utm
+	}
+	if err = s.saveToFile(record); err != nil {
+		return err
+	}
+	s.apply(record)
+	return nil
 }
 
-// newScanner для чтения из файла без буфера
-func (s *FileStorage) newScanner() (*bufio.Scanner, error) {
-	data, err := os.ReadFile(s.FilePath)
+// UpdateURL дописывает изменение урла в журнал. При чтении журнала изменения
+// применяются в том же порядке, так что урл и его история восстанавливаются целиком
+func (s *FileStorage) UpdateURL(
+	ctx context.Context, uID models.UserID, sID models.ShortenID, update func(*models.Target) error,
+) (*models.URLChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[sID]
+	if err := checkOwner(ok, entry.UserID, uID); err != nil {
+		return nil, err
+	}
+	if entry.IsDeleted {
+		return nil, ErrURLDeleted
+	}
+	if entry.IsPurged {
+		return nil, ErrURLExpired
+	}
+
+	change := models.URLChange{
+		UserID:    uID,
+		ChangedAt: time.Now(),
+		Old:       models.Target{FullURL: entry.FullURL, URLOptions: entry.URLOptions},
+	}
+	change.New = change.Old.Clone()
+	if err := update(&change.New); err != nil {
+		return nil, err
+	}
+	if change.New.Equal(change.Old) {
+		return nil, ErrURLNotModified
+	}
+
+	newUUID, err := uuid.NewUUID()
 	if err != nil {
 		return nil, err
 	}
-	buf := bytes.NewReader(data)
-	return bufio.NewScanner(buf), nil
+	record := models.FileJSONEntry{
+		ID:        &newUUID,
+		ShortenID: sID,
+		Change:    &change,
+	}
+	if err = s.saveToFile(record); err != nil {
+		return nil, err
+	}
+	s.apply(record)
+	return &change, nil
+}
+
+func (s *FileStorage) GetURLHistory(ctx context.Context, uID models.UserID, sID models.ShortenID) ([]models.URLChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[sID]
+	if err := checkOwner(ok, entry.UserID, uID); err != nil {
+		return nil, err
+	}
+	return slices.Clone(s.history[sID]), nil
 }
 
-func (s *FileStorage) saveToFile(entry models.FileJSONEntry) error {
-	file, err := os.OpenFile(s.FilePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, s.FilePerm)
+func (s *FileStorage) GetStats(ctx context.Context, sID models.ShortenID) (*models.URLStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.entries[sID]; !ok {
+		return nil, nil
+	}
+	stats, ok := s.stats[sID]
+	if !ok {
+		return emptyStats(), nil
+	}
+	return stats.snapshot(), nil
+}
+
+// Bootstrap создает файл, если его нет, вычитывает журнал в индекс и
+// открывает файл на дозапись
+func (s *FileStorage) Bootstrap(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.FilePath, os.O_RDWR|os.O_CREATE, s.FilePerm)
 	if err != nil {
 		return err
 	}
-	defer func(file *os.File) {
-		if err := file.Close(); err != nil {
-			logger.Log.Info().Err(err).Send()
+
+	if err = s.replay(file); err != nil {
+		return errors.Join(err, file.Close())
+	}
+	if _, err = file.Seek(0, io.SeekEnd); err != nil {
+		return errors.Join(err, file.Close())
+	}
+	s.file = file
+
+	// счетчик в памяти продолжается с количества когда-либо добавленных записей,
+	// чтобы не выдавать заново уже занятые идентификаторы
+	if r, ok := s.gen.(service.Resumable); ok {
+		r.Resume(s.issued)
+	}
+
+	if s.SyncPolicy == SyncInterval {
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.syncLoop()
+	}
+
+	logger.Log.Info().Int("entries", len(s.entries)).Str("path", s.FilePath).Msg("file storage is loaded")
+	return nil
+}
+
+// Ping проверяет, что файл журнала открыт и доступен
+func (s *FileStorage) Ping(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.file == nil {
+		return errors.New("storage file is not opened")
+	}
+	_, err := s.file.Stat()
+	return err
+}
+
+// Close сбрасывает данные на диск и закрывает файл журнала
+func (s *FileStorage) Close(ctx context.Context) error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return errors.New("storage file doesn't opened or already closed")
+	}
+	err := errors.Join(s.file.Sync(), s.file.Close())
+	s.file = nil
+	return err
+}
+
+// replay восстанавливает индекс по журналу. Битые строки в конце файла, оставшиеся
+// после аварийного завершения, отрезаются; битая строка в середине журнала — ошибка
+func (s *FileStorage) replay(file *os.File) error {
+	r := bufio.NewReader(file)
+
+	var offset, corruptOffset int64 = 0, -1
+	var lastLineTerminated = true
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			lastLineTerminated = line[len(line)-1] == '\n'
+
+			var entry models.FileJSONEntry
+			data := bytes.TrimSpace(line)
+			switch {
+			case len(data) == 0:
+			case json.Unmarshal(data, &entry) != nil:
+				if corruptOffset < 0 {
+					corruptOffset = offset
+				}
+			case corruptOffset >= 0:
+				return fmt.Errorf("storage file %s is corrupted at offset %d", s.FilePath, corruptOffset)
+			default:
+				s.apply(entry)
+			}
+			offset += int64(len(line))
 		}
-	}(file)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
 
-	w := json.NewEncoder(file)
+	if corruptOffset >= 0 {
+		logger.Log.Warn().
+			Str("path", s.FilePath).
+			Int64("offset", corruptOffset).
+			Int64("bytes", offset-corruptOffset).
+			Msg("truncating corrupted tail of storage file")
+		if err := file.Truncate(corruptOffset); err != nil {
+			return err
+		}
+		return nil
+	}
 
-	if err = w.Encode(entry); err != nil {
-		return err
+	if !lastLineTerminated {
+		if _, err := file.WriteAt([]byte{'\n'}, offset); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
+// apply применяет запись журнала к индексу
+func (s *FileStorage) apply(entry models.FileJSONEntry) {
+	switch {
+	case entry.IsDeleted:
+		if saved, ok := s.entries[entry.ShortenID]; ok {
+			saved.IsDeleted = true
+			s.entries[entry.ShortenID] = saved
+			// удаленный урл можно сократить заново, поэтому он уходит из индекса
+			if indexed, ok := s.byURL[saved.FullURL]; ok && indexed == entry.ShortenID {
+				delete(s.byURL, saved.FullURL)
+			}
+		}
+		return
+	case entry.IsPurged:
+		saved, ok := s.entries[entry.ShortenID]
+		if !ok {
+			return
+		}
+		if indexed, ok := s.byURL[saved.FullURL]; ok && indexed == entry.ShortenID {
+			delete(s.byURL, saved.FullURL)
+		}
+		s.entries[entry.ShortenID] = models.FileJSONEntry{
+			ID:        saved.ID,
+			ShortenID: saved.ShortenID,
+			UserID:    saved.UserID,
+			CreatedAt: saved.CreatedAt,
+			IsDeleted: saved.IsDeleted,
+			IsPurged:  true,
+		}
+		delete(s.stats, entry.ShortenID)
+		delete(s.history, entry.ShortenID)
+		return
+	case entry.IsHit:
+		if saved, ok := s.entries[entry.ShortenID]; ok {
+			saved.Clicks++
+			s.entries[entry.ShortenID] = saved
+		}
+		return
+	case entry.UTMDefaults != nil:
+		if entry.UTMDefaults.IsZero() {
+			delete(s.utmDefaults, entry.UserID)
+		} else {
+			s.utmDefaults[entry.UserID] = *entry.UTMDefaults
+		}
+		return
+	case entry.Change != nil:
+		saved, ok := s.entries[entry.ShortenID]
+		if !ok {
+			return
+		}
+		// урл с изменяемой целью не должен выдаваться тем, кто сокращает его прежний урл
+		if indexed, ok := s.byURL[saved.FullURL]; ok && indexed == entry.ShortenID {
+			delete(s.byURL, saved.FullURL)
+		}
+		saved.FullURL = entry.Change.New.FullURL
+		saved.URLOptions = entry.Change.New.URLOptions
+		s.entries[entry.ShortenID] = saved
+		s.history[entry.ShortenID] = append(s.history[entry.ShortenID], *entry.Change)
+		return
+	case entry.Stats != nil:
+		if saved, ok := s.entries[entry.ShortenID]; !ok || saved.IsPurged {
+			return
+		}
+		stats, ok := s.stats[entry.ShortenID]
+		if !ok {
+			stats = newClickStats()
+			s.stats[entry.ShortenID] = stats
+		}
+		stats.merge(*entry.Stats)
+		return
+	case entry.Click != nil:
+		// отдельные события пишутся только журналами прежних версий
+		if saved, ok := s.entries[entry.ShortenID]; !ok || saved.IsPurged {
+			return
+		}
+		// в журнале ShortenID хранится в самой записи, а не в событии
+		click := *entry.Click
+		click.ShortenID = entry.ShortenID
+		stats, ok := s.stats[entry.ShortenID]
+		if !ok {
+			stats = newClickStats()
+			s.stats[entry.ShortenID] = stats
+		}
+		stats.add(click)
+		return
+	}
+	s.entries[entry.ShortenID] = entry
+	s.issued++
+	if !entry.IsAlias && !entry.Distinct() {
+		s.byURL[entry.FullURL] = entry.ShortenID
+	}
+}
+
+// resolveFileEntry проверяет, что по записи можно перейти
+func resolveFileEntry(entry models.FileJSONEntry, now time.Time) error {
+	if entry.IsDeleted {
+		return ErrURLDeleted
+	}
+	if entry.IsPurged || entry.Expired(now, entry.Clicks) {
+		return ErrURLExpired
+	}
 	return nil
 }
 
-// fileExists проверяет существует ли FilePath
-func (s *FileStorage) fileExists() bool {
-	_, err := os.Stat(s.FilePath)
-	return !os.IsNotExist(err)
+// newShortenID генерирует не занятый в индексе короткий идентификатор
+func (s *FileStorage) newShortenID(ctx context.Context, fURL models.FullURL) (models.ShortenID, error) {
+	return s.newFreeShortenID(ctx, fURL, func(sID models.ShortenID) bool {
+		_, ok := s.entries[sID]
+		return ok
+	})
 }
 
-func (s *FileStorage) createFile() error {
-	f, err := os.Create(s.FilePath)
+// newFreeShortenID генерирует идентификатор, для которого taken возвращает false
+func (s *FileStorage) newFreeShortenID(ctx context.Context, fURL models.FullURL, taken func(models.ShortenID) bool) (models.ShortenID, error) {
+	for attempt := 0; attempt < maxIDAttempts; attempt++ {
+		sID, err := s.gen.Generate(ctx, fURL, attempt)
+		if err != nil {
+			return "", err
+		}
+		if !taken(sID) {
+			return sID, nil
+		}
+	}
+	return "", ErrIDCollision
+}
+
+// saveToFile дописывает записи в журнал одной операцией записи. Если запись
+// не удалась, недописанный хвост отрезается. Вызывается под s.mu
+func (s *FileStorage) saveToFile(entries ...models.FileJSONEntry) error {
+	if s.file == nil {
+		return errors.New("storage file is not opened")
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	offset, err := s.file.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return err
 	}
-	if err = f.Close(); err != nil {
-		return err
+	if _, err = s.file.Write(buf.Bytes()); err != nil {
+		if _, seekErr := s.file.Seek(offset, io.SeekStart); seekErr != nil {
+			return errors.Join(err, seekErr)
+		}
+		return errors.Join(err, s.file.Truncate(offset))
+	}
+
+	if s.SyncPolicy == SyncAlways {
+		return s.file.Sync()
 	}
+	s.dirty = true
 	return nil
 }
+
+// syncLoop периодически сбрасывает записанные данные на диск
+func (s *FileStorage) syncLoop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.dirty && s.file != nil {
+				if err := s.file.Sync(); err != nil {
+					logger.Log.Error().Err(err).Msg("error while syncing storage file")
+				} else {
+					s.dirty = false
+				}
+			}
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+	"github.com/nartim88/urlshortener/internal/pkg/service"
+)
+
+func newTestFileStorage(t *testing.T, path string) *FileStorage {
+	s, err := NewFileStorage(path, SyncAlways, time.Second, service.RandomGenerator{Length: 8})
+	require.NoError(t, err)
+	require.NoError(t, s.Bootstrap(context.Background()))
+	t.Cleanup(func() {
+		_ = s.Close(context.Background())
+	})
+	return s.(*FileStorage)
+}
+
+func TestFileStorageReplay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	s := newTestFileStorage(t, path)
+	sID, err := s.Set(ctx, "https://ya.ru", "user", models.SetOptions{})
+	require.NoError(t, err)
+	deletedSID, err := s.Set(ctx, "https://google.ru", "user", models.SetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteURLs(ctx, []models.DeleteTask{{UserID: "user", ShortenID: *deletedSID}}))
+	require.NoError(t, s.Close(ctx))
+
+	t.Run("restores_index", func(t *testing.T) {
+		s := newTestFileStorage(t, path)
+
+		fURL, err := s.Get(ctx, *sID)
+		require.NoError(t, err)
+		assert.Equal(t, models.FullURL("https://ya.ru"), *fURL)
+
+		_, err = s.Get(ctx, *deletedSID)
+		assert.ErrorIs(t, err, ErrURLDeleted)
+
+		_, err = s.Set(ctx, "https://ya.ru", "user", models.SetOptions{})
+		assert.ErrorAs(t, err, &URLExistsError{})
+
+		entry, err := s.GetEntry(ctx, *sID)
+		require.NoError(t, err)
+		assert.Equal(t, models.UserID("user"), entry.UserID)
+		assert.False(t, entry.CreatedAt.IsZero(), "creation time is restored from the journal")
+
+		found, err := s.GetByFullURL(ctx, "https://ya.ru")
+		require.NoError(t, err)
+		assert.Equal(t, sID, found)
+		found, err = s.GetByFullURL(ctx, "https://google.ru")
+		require.NoError(t, err)
+		assert.Nil(t, found, "deleted url is not found")
+
+		_, err = s.Set(ctx, "https://google.ru", "user", models.SetOptions{})
+		assert.NoError(t, err, "deleted url must leave the dedup index")
+	})
+
+	t.Run("truncates_corrupted_tail", func(t *testing.T) {
+		before, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0666)
+		require.NoError(t, err)
+		_, err = f.WriteString(`{"id":"broken","shorten_`)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		s := newTestFileStorage(t, path)
+		fURL, err := s.Get(ctx, *sID)
+		require.NoError(t, err)
+		assert.Equal(t, models.FullURL("https://ya.ru"), *fURL)
+		require.NoError(t, s.Close(ctx))
+
+		after, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+	})
+
+	t.Run("fails_on_corrupted_middle", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "storage.json")
+		data := "not a json\n" + `{"shorten_id":"abc","full_url":"https://ya.ru"}` + "\n"
+		require.NoError(t, os.WriteFile(path, []byte(data), 0666))
+
+		s, err := NewFileStorage(path, SyncNever, 0, service.RandomGenerator{Length: 8})
+		require.NoError(t, err)
+		assert.Error(t, s.Bootstrap(ctx))
+	})
+}
+
+func TestFileStorageReplayClicks(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	s := newTestFileStorage(t, path)
+	sID, err := s.Set(ctx, "https://ya.ru", "user", models.SetOptions{URLOptions: models.URLOptions{MaxClicks: 2}})
+	require.NoError(t, err)
+	_, err = s.Hit(ctx, *sID)
+	require.NoError(t, err)
+	require.NoError(t, s.SaveClicks(ctx, []models.ClickEvent{
+		{ShortenID: *sID, Time: time.Now(), Referrer: "t.me", IPHash: "a"},
+	}))
+	require.NoError(t, s.Close(ctx))
+
+	s = newTestFileStorage(t, path)
+	target, err := s.Hit(ctx, *sID)
+	require.NoError(t, err)
+	assert.Equal(t, &models.Target{FullURL: "https://ya.ru", URLOptions: models.URLOptions{MaxClicks: 2}}, target)
+	_, err = s.Hit(ctx, *sID)
+	assert.ErrorIs(t, err, ErrURLExpired)
+
+	stats, err := s.GetStats(ctx, *sID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.TotalClicks)
+	assert.Equal(t, int64(1), stats.ByReferrer["t.me"])
+}
+
+func TestFileStorageSetBatch(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	s := newTestFileStorage(t, path)
+	existing, err := s.Set(ctx, "https://ya.ru", "user", models.SetOptions{})
+	require.NoError(t, err)
+
+	results, err := s.SetBatch(ctx, "user", []models.BatchItem{
+		{FullURL: "https://ya.ru"},
+		{FullURL: "https://google.ru"},
+		{FullURL: "https://google.ru", SetOptions: models.SetOptions{Alias: "google"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, models.BatchResult{ShortenID: *existing}, results[0])
+	assert.True(t, results[1].Created)
+	assert.Equal(t, models.BatchResult{ShortenID: "google", Created: true}, results[2])
+
+	results, err = s.SetBatch(ctx, "user", []models.BatchItem{
+		{FullURL: "https://go.dev"},
+		{FullURL: "https://go.dev", SetOptions: models.SetOptions{Alias: "google"}},
+	})
+	require.NoError(t, err)
+	assert.True(t, results[0].Created)
+	assert.ErrorAs(t, results[1].Err, &AliasExistsError{})
+	require.NoError(t, s.Close(ctx))
+
+	s = newTestFileStorage(t, path)
+	urls, err := s.GetUserURLs(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, urls, 4)
+}
+
+func TestFileStorageReplayUTMDefaults(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	s := newTestFileStorage(t, path)
+	require.NoError(t, s.SetUTMDefaults(ctx, "user", &models.UTM{Source: "mail"}))
+	require.NoError(t, s.SetUTMDefaults(ctx, "other", &models.UTM{Source: "ads"}))
+	require.NoError(t, s.SetUTMDefaults(ctx, "other", nil))
+	require.NoError(t, s.Close(ctx))
+
+	s = newTestFileStorage(t, path)
+	utm, err := s.GetUTMDefaults(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, &models.UTM{Source: "mail"}, utm)
+
+	utm, err = s.GetUTMDefaults(ctx, "other")
+	require.NoError(t, err)
+	assert.Nil(t, utm)
+}
+
+func TestFileStorageReplayURLChanges(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	oldURL, newURL := models.FullURL("https://ya.ru/old"), models.FullURL("https://ya.ru/new")
+	s := newTestFileStorage(t, path)
+	sID, err := s.Set(ctx, oldURL, "user", models.SetOptions{})
+	require.NoError(t, err)
+	change, err := s.UpdateURL(ctx, "user", *sID, func(target *models.Target) error {
+		target.FullURL = newURL
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Close(ctx))
+
+	s = newTestFileStorage(t, path)
+	fURL, err := s.Get(ctx, *sID)
+	require.NoError(t, err)
+	assert.Equal(t, newURL, *fURL)
+
+	history, err := s.GetURLHistory(ctx, "user", *sID)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, change.Old, history[0].Old)
+	assert.Equal(t, change.New, history[0].New)
+	assert.True(t, change.ChangedAt.Equal(history[0].ChangedAt))
+
+	other, err := s.Set(ctx, oldURL, "user", models.SetOptions{})
+	require.NoError(t, err, "edited url must leave the dedup index")
+	assert.NotEqual(t, *sID, *other)
+}
+
+func TestFileStorageReplayPurge(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	s := newTestFileStorage(t, path)
+	opts := models.SetOptions{Alias: "promo", URLOptions: models.URLOptions{MaxClicks: 1}}
+	_, err := s.Set(ctx, "https://ya.ru", "user", opts)
+	require.NoError(t, err)
+	_, err = s.UpdateURL(ctx, "user", "promo", func(target *models.Target) error {
+		target.FullURL = "https://ya.ru/new"
+		return nil
+	})
+	require.NoError(t, err)
+	_, err = s.Hit(ctx, "promo")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveClicks(ctx, []models.ClickEvent{{ShortenID: "promo", Time: time.Now()}}))
+
+	n, err := s.PurgeExpired(ctx, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	require.NoError(t, s.Close(ctx))
+
+	s = newTestFileStorage(t, path)
+	n, err = s.PurgeExpired(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, n, "tombstones are not purged again")
+
+	_, err = s.Hit(ctx, "promo")
+	assert.ErrorIs(t, err, ErrURLExpired, "purged url must stay gone after replay")
+	var aliasErr AliasExistsError
+	_, err = s.Set(ctx, "https://go.dev", "other", models.SetOptions{Alias: "promo"})
+	assert.ErrorAs(t, err, &aliasErr, "alias of a purged url must not be handed out again")
+
+	entry, err := s.GetEntry(ctx, "promo")
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.Equal(t, models.UserID("user"), entry.UserID)
+	assert.True(t, entry.IsExpired)
+	assert.Empty(t, entry.FullURL)
+	assert.Zero(t, entry.Clicks, "stats of the purged url must not be replayed")
+
+	history, err := s.GetURLHistory(ctx, "user", "promo")
+	require.NoError(t, err)
+	assert.Empty(t, history, "history of the purged url must not be replayed")
+}
+
+func TestFileStorageSaveClicksAggregates(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	s := newTestFileStorage(t, path)
+	sID, err := s.Set(ctx, "https://ya.ru", "user", models.SetOptions{})
+	require.NoError(t, err)
+	before, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	events := make([]models.ClickEvent, 0, 100)
+	for i := 0; i < 100; i++ {
+		events = append(events, models.ClickEvent{
+			ShortenID: *sID, Time: day, Referrer: "t.me", UserAgent: "curl", IPHash: fmt.Sprint(i % 3),
+		})
+	}
+	require.NoError(t, s.SaveClicks(ctx, events))
+	require.NoError(t, s.SaveClicks(ctx, events[:1]))
+	require.NoError(t, s.Close(ctx))
+
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	written := string(after[len(before):])
+	assert.Equal(t, 2, strings.Count(written, "\n"), "one record per url and batch")
+	assert.NotContains(t, written, "curl", "raw events are not journaled")
+
+	s = newTestFileStorage(t, path)
+	stats, err := s.GetStats(ctx, *sID)
+	require.NoError(t, err)
+	assert.Equal(t, &models.URLStats{
+		TotalClicks:    101,
+		UniqueVisitors: 3,
+		ByDay:          map[string]int64{"2024-03-01": 101},
+		ByReferrer:     map[string]int64{"t.me": 101},
+	}, stats)
+}
+
+func TestFileStorageResumeAfterPurge(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	open := func() *FileStorage {
+		gen := service.CounterGenerator{Seq: service.NewMemSequence(), Length: 4}
+		s, err := NewFileStorage(path, SyncAlways, time.Second, gen)
+		require.NoError(t, err)
+		require.NoError(t, s.Bootstrap(ctx))
+		return s.(*FileStorage)
+	}
+
+	s := open()
+	expiresAt := time.Now().Add(time.Hour)
+	for i := 0; i < maxIDAttempts; i++ {
+		opts := models.SetOptions{URLOptions: models.URLOptions{ExpiresAt: &expiresAt}}
+		_, err := s.Set(ctx, models.FullURL(fmt.Sprintf("https://ya.ru/expired/%d", i)), "user", opts)
+		require.NoError(t, err)
+	}
+	// идентификаторы, выданные после истекших, остаются заняты
+	kept := make(map[models.ShortenID]struct{})
+	for i := 0; i < maxIDAttempts; i++ {
+		sID, err := s.Set(ctx, models.FullURL(fmt.Sprintf("https://ya.ru/kept/%d", i)), "user", models.SetOptions{})
+		require.NoError(t, err)
+		kept[*sID] = struct{}{}
+	}
+	n, err := s.PurgeExpired(ctx, expiresAt.Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, maxIDAttempts, n)
+	require.NoError(t, s.Close(ctx))
+
+	s = open()
+	defer func() {
+		_ = s.Close(ctx)
+	}()
+	sID, err := s.Set(ctx, "https://ya.ru/new", "user", models.SetOptions{})
+	require.NoError(t, err, "counter must not restart below ids still in use")
+	assert.NotContains(t, kept, *sID)
+}
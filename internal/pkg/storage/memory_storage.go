@@ -3,44 +3,567 @@ package storage
 import (
 	"context"
 	"errors"
+	"hash/maphash"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/nartim88/urlshortener/internal/pkg/models"
 	"github.com/nartim88/urlshortener/internal/pkg/service"
 )
 
+// memShardCount количество шардов, на которые делятся данные MemStorage,
+// чтобы конкурентные запросы реже ждали друг друга на блокировках
+const memShardCount = 32
+
+// MemStorage потокобезопасное хранилище в памяти. Записи шардированы по короткому
+// идентификатору, индекс для дедупликации — по полному урлу
 type MemStorage struct {
-	Memory map[models.ShortenID]models.FullURL
+	gen       service.IDGenerator
+	seed      maphash.Seed
+	shards    [memShardCount]memShard
+	urlShards [memShardCount]urlShard
+
+	statsMu sync.Mutex
+	stats   map[models.ShortenID]*clickStats
+
+	utmMu       sync.RWMutex
+	utmDefaults map[models.UserID]models.UTM
+
+	historyMu sync.RWMutex
+	history   map[models.ShortenID][]models.URLChange
+}
+
+// memEntry данные сокращенного урла, хранящиеся в памяти
+type memEntry struct {
+	FullURL   models.FullURL
+	UserID    models.UserID
+	CreatedAt time.Time
+	IsDeleted bool
+	// IsPurged надгробие истекшего урла, от которого PurgeExpired оставил только владельца
+	// и момент создания. Идентификатор остается занят
+	IsPurged bool
+	models.URLOptions
+	Clicks int64
+}
+
+// resolve проверяет, что по записи можно перейти
+func (e memEntry) resolve(now time.Time) error {
+	if e.IsDeleted {
+		return ErrURLDeleted
+	}
+	if e.IsPurged || e.Expired(now, e.Clicks) {
+		return ErrURLExpired
+	}
+	return nil
+}
+
+type memShard struct {
+	mu      sync.RWMutex
+	entries map[models.ShortenID]memEntry
+}
+
+type urlShard struct {
+	mu    sync.Mutex
+	byURL map[models.FullURL]models.ShortenID
 }
 
 // NewMemStorage инициализация Storage в памяти
-func NewMemStorage() Storage {
+func NewMemStorage(gen service.IDGenerator) Storage {
 	s := MemStorage{
-		make(map[models.ShortenID]models.FullURL),
+		gen:         gen,
+		seed:        maphash.MakeSeed(),
+		stats:       make(map[models.ShortenID]*clickStats),
+		utmDefaults: make(map[models.UserID]models.UTM),
+		history:     make(map[models.ShortenID][]models.URLChange),
+	}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[models.ShortenID]memEntry)
+		s.urlShards[i].byURL = make(map[models.FullURL]models.ShortenID)
 	}
 	return &s
 }
 
 func (s *MemStorage) Get(ctx context.Context, sID models.ShortenID) (*models.FullURL, error) {
-	if !s.isExist(sID) {
+	shard := s.shard(sID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	entry, ok := shard.entries[sID]
+	if !ok {
+		return nil, nil
+	}
+	if err := entry.resolve(time.Now()); err != nil {
+		return nil, err
+	}
+	return &entry.FullURL, nil
+}
+
+func (s *MemStorage) Lookup(ctx context.Context, sID models.ShortenID) (*models.Target, error) {
+	shard := s.shard(sID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	entry, ok := shard.entries[sID]
+	if !ok {
+		return nil, nil
+	}
+	if err := entry.resolve(time.Now()); err != nil {
+		return nil, err
+	}
+	return &models.Target{FullURL: entry.FullURL, URLOptions: entry.URLOptions}, nil
+}
+
+func (s *MemStorage) GetEntry(ctx context.Context, sID models.ShortenID) (*models.URLEntry, error) {
+	shard := s.shard(sID)
+	shard.mu.RLock()
+	entry, ok := shard.entries[sID]
+	shard.mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+
+	urlEntry := models.URLEntry{
+		ShortenID: sID,
+		UserID:    entry.UserID,
+		CreatedAt: entry.CreatedAt,
+		Target:    models.Target{FullURL: entry.FullURL, URLOptions: entry.URLOptions},
+		IsDeleted: entry.IsDeleted,
+		IsExpired: entry.IsPurged || entry.Expired(time.Now(), entry.Clicks),
+	}
+
+	s.statsMu.Lock()
+	if stats, ok := s.stats[sID]; ok {
+		urlEntry.Clicks = stats.total
+	}
+	s.statsMu.Unlock()
+
+	return &urlEntry, nil
+}
+
+func (s *MemStorage) Hit(ctx context.Context, sID models.ShortenID) (*models.Target, error) {
+	shard := s.shard(sID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry, ok := shard.entries[sID]
+	if !ok {
+		return nil, nil
+	}
+	if err := entry.resolve(time.Now()); err != nil {
+		return nil, err
+	}
+	if entry.MaxClicks > 0 {
+		entry.Clicks++
+		shard.entries[sID] = entry
+	}
+	return &models.Target{FullURL: entry.FullURL, URLOptions: entry.URLOptions}, nil
+}
+
+func (s *MemStorage) Set(ctx context.Context, fURL models.FullURL, uID models.UserID, opts models.SetOptions) (*models.ShortenID, error) {
+	entry := memEntry{
+		FullURL:    fURL,
+		UserID:     uID,
+		CreatedAt:  time.Now(),
+		URLOptions: opts.URLOptions,
+	}
+
+	// урлы с алиасом или параметрами, исключающими дедупликацию, не попадают в индекс
+	if opts.Alias != "" {
+		if !s.insert(opts.Alias, entry) {
+			return nil, AliasExistsError{opts.Alias}
+		}
+		return &opts.Alias, nil
+	}
+	if opts.Distinct() {
+		return s.insertGenerated(ctx, entry)
+	}
+
+	// блокировка шарда полного урла держится до вставки записи, чтобы один и тот же
+	// урл не был сохранен дважды конкурентными запросами
+	us := s.urlShard(fURL)
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if sID, ok := us.byURL[fURL]; ok {
+		return nil, URLExistsError{fURL, sID}
+	}
+
+	sID, err := s.insertGenerated(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+	us.byURL[fURL] = *sID
+	return sID, nil
+}
+
+// SetBatch на время сохранения блокирует все шарды, так что пакет становится
+// виден другим запросам целиком
+func (s *MemStorage) SetBatch(ctx context.Context, uID models.UserID, items []models.BatchItem) ([]models.BatchResult, error) {
+	unlock := s.lockAll()
+	defer unlock()
+
+	results := make([]models.BatchResult, len(items))
+	pending := make(map[models.ShortenID]memEntry, len(items))
+	pendingURLs := make(map[models.FullURL]models.ShortenID)
+
+	taken := func(sID models.ShortenID) bool {
+		_, inStore := s.shard(sID).entries[sID]
+		_, inBatch := pending[sID]
+		return inStore || inBatch
+	}
+
+	now := time.Now()
+	for i, item := range items {
+		entry := memEntry{
+			FullURL:    item.FullURL,
+			UserID:     uID,
+			CreatedAt:  now,
+			URLOptions: item.URLOptions,
+		}
+
+		if item.Alias != "" {
+			if taken(item.Alias) {
+				results[i].Err = AliasExistsError{item.Alias}
+				continue
+			}
+			pending[item.Alias] = entry
+			results[i] = models.BatchResult{ShortenID: item.Alias, Created: true}
+			continue
+		}
+
+		if !item.Distinct() {
+			if sID, ok := s.urlShard(item.FullURL).byURL[item.FullURL]; ok {
+				results[i] = models.BatchResult{ShortenID: sID}
+				continue
+			}
+			if sID, ok := pendingURLs[item.FullURL]; ok {
+				results[i] = models.BatchResult{ShortenID: sID}
+				continue
+			}
+		}
+
+		sID, err := s.generateFree(ctx, item.FullURL, taken)
+		if errors.Is(err, ErrIDCollision) {
+			results[i].Err = err
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pending[sID] = entry
+		if !item.Distinct() {
+			pendingURLs[item.FullURL] = sID
+		}
+		results[i] = models.BatchResult{ShortenID: sID, Created: true}
+	}
+
+	for sID, entry := range pending {
+		s.shard(sID).entries[sID] = entry
+	}
+	for fURL, sID := range pendingURLs {
+		s.urlShard(fURL).byURL[fURL] = sID
+	}
+	return results, nil
+}
+
+func (s *MemStorage) GetByFullURL(ctx context.Context, fURL models.FullURL) (*models.ShortenID, error) {
+	us := s.urlShard(fURL)
+	us.mu.Lock()
+	sID, ok := us.byURL[fURL]
+	us.mu.Unlock()
+	if !ok {
 		return nil, nil
 	}
-	fURL := s.Memory[sID]
-	return &fURL, nil
+
+	shard := s.shard(sID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	if entry, ok := shard.entries[sID]; !ok || entry.IsDeleted {
+		return nil, nil
+	}
+	return &sID, nil
+}
+
+func (s *MemStorage) GetUserURLs(ctx context.Context, uID models.UserID) ([]models.UserURL, error) {
+	var urls []models.UserURL
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.RLock()
+		for sID, entry := range shard.entries {
+			if entry.UserID == uID && !entry.IsDeleted && !entry.IsPurged {
+				urls = append(urls, models.UserURL{ShortenID: sID, FullURL: entry.FullURL})
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	return urls, nil
+}
+
+// DeleteURLs убирает удаленный урл из индекса для дедупликации, чтобы его полный урл
+// можно было сократить заново. Индекс блокируется после шарда записи, а не вместе с ним,
+// чтобы не нарушать порядок блокировок Set; запись индекса удаляется, только если
+// она все еще указывает на удаленный урл
+func (s *MemStorage) DeleteURLs(ctx context.Context, tasks []models.DeleteTask) error {
+	for _, t := range tasks {
+		shard := s.shard(t.ShortenID)
+		shard.mu.Lock()
+		entry, ok := shard.entries[t.ShortenID]
+		deleted := ok && entry.UserID == t.UserID && !entry.IsDeleted
+		if deleted {
+			entry.IsDeleted = true
+			shard.entries[t.ShortenID] = entry
+		}
+		shard.mu.Unlock()
+		if !deleted {
+			continue
+		}
+
+		us := s.urlShard(entry.FullURL)
+		us.mu.Lock()
+		if indexed, ok := us.byURL[entry.FullURL]; ok && indexed == t.ShortenID {
+			delete(us.byURL, entry.FullURL)
+		}
+		us.mu.Unlock()
+	}
+	return nil
+}
+
+// PurgeExpired заменяет истекшие записи надгробиями и удаляет их статистику и историю
+// под блокировкой шарда, так что переход, учтенный в тот же момент, не вернет их обратно.
+// Такие записи не попадают в индекс для дедупликации, поэтому чистить его не нужно
+func (s *MemStorage) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	var purged int
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.Lock()
+		var expired []models.ShortenID
+		for sID, entry := range shard.entries {
+			if !entry.IsPurged && entry.Expired(now, entry.Clicks) {
+				shard.entries[sID] = memEntry{
+					UserID:    entry.UserID,
+					CreatedAt: entry.CreatedAt,
+					IsDeleted: entry.IsDeleted,
+					IsPurged:  true,
+				}
+				expired = append(expired, sID)
+			}
+		}
+		if len(expired) > 0 {
+			s.statsMu.Lock()
+			s.historyMu.Lock()
+			for _, sID := range expired {
+				delete(s.stats, sID)
+				delete(s.history, sID)
+			}
+			s.historyMu.Unlock()
+			s.statsMu.Unlock()
+		}
+		shard.mu.Unlock()
+		purged += len(expired)
+	}
+	return purged, nil
+}
+
+// SaveClicks учитывает событие под блокировкой шарда урла, чтобы оно не попало
+// в статистику урла, очищенного PurgeExpired в тот же момент
+func (s *MemStorage) SaveClicks(ctx context.Context, events []models.ClickEvent) error {
+	for _, e := range events {
+		shard := s.shard(e.ShortenID)
+		shard.mu.RLock()
+		if entry, ok := shard.entries[e.ShortenID]; ok && !entry.IsPurged {
+			s.statsMu.Lock()
+			stats, ok := s.stats[e.ShortenID]
+			if !ok {
+				stats = newClickStats()
+				s.stats[e.ShortenID] = stats
+			}
+			stats.add(e)
+			s.statsMu.Unlock()
+		}
+		shard.mu.RUnlock()
+	}
+	return nil
 }
 
-func (s *MemStorage) Set(ctx context.Context, fURL models.FullURL) (*models.ShortenID, error) {
-	randChars := service.GenerateRandChars(shortURLLen)
-	shortURL := models.ShortenID(randChars)
+func (s *MemStorage) GetStats(ctx context.Context, sID models.ShortenID) (*models.URLStats, error) {
+	if !s.exists(sID) {
+		return nil, nil
+	}
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
 
-	if s.isExist(shortURL) {
-		return nil, errors.New("URL already exists")
+	stats, ok := s.stats[sID]
+	if !ok {
+		return emptyStats(), nil
 	}
-	s.Memory[shortURL] = fURL
-	return &shortURL, nil
+	return stats.snapshot(), nil
 }
 
-// isExist проверяет сохранен ли в памяти короткий УРЛ
-func (s *MemStorage) isExist(sID models.ShortenID) bool {
-	_, ok := s.Memory[sID]
+func (s *MemStorage) GetUTMDefaults(ctx context.Context, uID models.UserID) (*models.UTM, error) {
+	s.utmMu.RLock()
+	defer s.utmMu.RUnlock()
+
+	utm, ok := s.utmDefaults[uID]
+	if !ok {
+		return nil, nil
+	}
+	return &utm, nil
+}
+
+func (s *MemStorage) SetUTMDefaults(ctx context.Context, uID models.UserID, utm *models.UTM) error {
+	s.utmMu.Lock()
+	defer s.utmMu.Unlock()
+
+	if utm == nil || utm.IsZero() {
+		delete(s.utmDefaults, uID)
+		return nil
+	}
+	s.utmDefaults[uID] = *utm
+	return nil
+}
+
+// UpdateURL на время изменения блокирует все шарды, чтобы вместе с записью убрать
+// урл из индекса для дедупликации. Изменения редки, так что это не мешает остальным запросам
+func (s *MemStorage) UpdateURL(
+	ctx context.Context, uID models.UserID, sID models.ShortenID, update func(*models.Target) error,
+) (*models.URLChange, error) {
+	unlock := s.lockAll()
+	defer unlock()
+
+	shard := s.shard(sID)
+	entry, ok := shard.entries[sID]
+	if err := checkOwner(ok, entry.UserID, uID); err != nil {
+		return nil, err
+	}
+	if entry.IsDeleted {
+		return nil, ErrURLDeleted
+	}
+	if entry.IsPurged {
+		return nil, ErrURLExpired
+	}
+
+	change := models.URLChange{
+		UserID:    uID,
+		ChangedAt: time.Now(),
+		Old:       models.Target{FullURL: entry.FullURL, URLOptions: entry.URLOptions},
+	}
+	change.New = change.Old.Clone()
+	if err := update(&change.New); err != nil {
+		return nil, err
+	}
+	if change.New.Equal(change.Old) {
+		return nil, ErrURLNotModified
+	}
+
+	// урл с изменяемой целью не должен выдаваться тем, кто сокращает его прежний урл
+	us := s.urlShard(entry.FullURL)
+	if indexed, ok := us.byURL[entry.FullURL]; ok && indexed == sID {
+		delete(us.byURL, entry.FullURL)
+	}
+	entry.FullURL = change.New.FullURL
+	entry.URLOptions = change.New.URLOptions
+	shard.entries[sID] = entry
+
+	s.historyMu.Lock()
+	s.history[sID] = append(s.history[sID], change)
+	s.historyMu.Unlock()
+
+	return &change, nil
+}
+
+func (s *MemStorage) GetURLHistory(ctx context.Context, uID models.UserID, sID models.ShortenID) ([]models.URLChange, error) {
+	shard := s.shard(sID)
+	shard.mu.RLock()
+	entry, ok := shard.entries[sID]
+	shard.mu.RUnlock()
+	if err := checkOwner(ok, entry.UserID, uID); err != nil {
+		return nil, err
+	}
+
+	s.historyMu.RLock()
+	defer s.historyMu.RUnlock()
+	return slices.Clone(s.history[sID]), nil
+}
+
+// exists проверяет, что запись с коротким идентификатором есть в хранилище
+func (s *MemStorage) exists(sID models.ShortenID) bool {
+	shard := s.shard(sID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	_, ok := shard.entries[sID]
 	return ok
 }
+
+// insertGenerated сохраняет запись под сгенерированным свободным идентификатором
+func (s *MemStorage) insertGenerated(ctx context.Context, entry memEntry) (*models.ShortenID, error) {
+	for attempt := 0; attempt < maxIDAttempts; attempt++ {
+		sID, err := s.gen.Generate(ctx, entry.FullURL, attempt)
+		if err != nil {
+			return nil, err
+		}
+		if s.insert(sID, entry) {
+			return &sID, nil
+		}
+	}
+	return nil, ErrIDCollision
+}
+
+// generateFree генерирует идентификатор, для которого taken возвращает false
+func (s *MemStorage) generateFree(ctx context.Context, fURL models.FullURL, taken func(models.ShortenID) bool) (models.ShortenID, error) {
+	for attempt := 0; attempt < maxIDAttempts; attempt++ {
+		sID, err := s.gen.Generate(ctx, fURL, attempt)
+		if err != nil {
+			return "", err
+		}
+		if !taken(sID) {
+			return sID, nil
+		}
+	}
+	return "", ErrIDCollision
+}
+
+// lockAll блокирует все шарды в том же порядке, что и Set: сначала индекс урлов,
+// затем записи. Возвращает функцию снятия блокировок
+func (s *MemStorage) lockAll() func() {
+	for i := range s.urlShards {
+		s.urlShards[i].mu.Lock()
+	}
+	for i := range s.shards {
+		s.shards[i].mu.Lock()
+	}
+	return func() {
+		for i := range s.shards {
+			s.shards[i].mu.Unlock()
+		}
+		for i := range s.urlShards {
+			s.urlShards[i].mu.Unlock()
+		}
+	}
+}
+
+// insert сохраняет запись, если короткий идентификатор еще не занят
+func (s *MemStorage) insert(sID models.ShortenID, entry memEntry) bool {
+	shard := s.shard(sID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if _, ok := shard.entries[sID]; ok {
+		return false
+	}
+	shard.entries[sID] = entry
+	return true
+}
+
+// shard возвращает шард, в котором хранится короткий идентификатор
+func (s *MemStorage) shard(sID models.ShortenID) *memShard {
+	return &s.shards[maphash.String(s.seed, string(sID))%memShardCount]
+}
+
+// urlShard возвращает шард индекса, в котором хранится полный урл
+func (s *MemStorage) urlShard(fURL models.FullURL) *urlShard {
+	return &s.urlShards[maphash.String(s.seed, string(fURL))%memShardCount]
+}
@@ -0,0 +1,413 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nartim88/urlshortener/internal/pkg/models"
+	"github.com/nartim88/urlshortener/internal/pkg/service"
+)
+
+func TestMemStorageConcurrentGetSet(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage(service.RandomGenerator{Length: 8})
+
+	const workers, perWorker = 16, 200
+
+	var wg sync.WaitGroup
+	sIDs := make([][]models.ShortenID, workers)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				fURL := models.FullURL(fmt.Sprintf("https://example.com/%d/%d", w, i))
+				sID, err := s.Set(ctx, fURL, "user", models.SetOptions{})
+				if !assert.NoError(t, err) {
+					return
+				}
+				sIDs[w] = append(sIDs[w], *sID)
+
+				got, err := s.Get(ctx, *sID)
+				if assert.NoError(t, err) && assert.NotNil(t, got) {
+					assert.Equal(t, fURL, *got)
+				}
+			}
+		}(w)
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				_, err := s.GetUserURLs(ctx, "user")
+				assert.NoError(t, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	unique := make(map[models.ShortenID]struct{})
+	for _, ids := range sIDs {
+		for _, sID := range ids {
+			unique[sID] = struct{}{}
+		}
+	}
+	assert.Len(t, unique, workers*perWorker)
+
+	urls, err := s.GetUserURLs(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, urls, workers*perWorker)
+}
+
+func TestMemStorageConcurrentSetSameURL(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage(service.RandomGenerator{Length: 8})
+
+	const workers = 32
+	fURL := models.FullURL("https://ya.ru")
+
+	var wg sync.WaitGroup
+	var created, exists atomic.Int32
+	var createdSID atomic.Value
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sID, err := s.Set(ctx, fURL, "user", models.SetOptions{})
+			var existsErr URLExistsError
+			switch {
+			case err == nil:
+				created.Add(1)
+				createdSID.Store(*sID)
+			case errors.As(err, &existsErr):
+				exists.Add(1)
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), created.Load())
+	assert.Equal(t, int32(workers-1), exists.Load())
+
+	_, err := s.Set(ctx, fURL, "other", models.SetOptions{})
+	var existsErr URLExistsError
+	require.ErrorAs(t, err, &existsErr)
+	assert.Equal(t, createdSID.Load(), existsErr.SID)
+}
+
+func TestMemStorageExpiration(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage(service.RandomGenerator{Length: 8})
+
+	fURL := models.FullURL("https://ya.ru")
+	_, err := s.Set(ctx, fURL, "user", models.SetOptions{})
+	require.NoError(t, err)
+
+	t.Run("max_clicks", func(t *testing.T) {
+		sID, err := s.Set(ctx, fURL, "user", models.SetOptions{URLOptions: models.URLOptions{MaxClicks: 2}})
+		require.NoError(t, err, "limited url must not be deduplicated")
+
+		const workers = 16
+		var wg sync.WaitGroup
+		var hits, gone atomic.Int32
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := s.Hit(ctx, *sID)
+				switch {
+				case err == nil:
+					hits.Add(1)
+				case errors.Is(err, ErrURLExpired):
+					gone.Add(1)
+				default:
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(2), hits.Load())
+		assert.Equal(t, int32(workers-2), gone.Load())
+		_, err = s.Get(ctx, *sID)
+		assert.ErrorIs(t, err, ErrURLExpired)
+	})
+
+	t.Run("expires_at", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Hour)
+		sID, err := s.Set(ctx, fURL, "user", models.SetOptions{URLOptions: models.URLOptions{ExpiresAt: &expiresAt}})
+		require.NoError(t, err)
+
+		_, err = s.Get(ctx, *sID)
+		require.NoError(t, err)
+
+		n, err := s.PurgeExpired(ctx, expiresAt.Add(-time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, 1, n, "only url with exhausted clicks is purged")
+
+		n, err = s.PurgeExpired(ctx, expiresAt)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		_, err = s.Get(ctx, *sID)
+		assert.ErrorIs(t, err, ErrURLExpired, "purged url must stay gone")
+
+		n, err = s.PurgeExpired(ctx, expiresAt)
+		require.NoError(t, err)
+		assert.Zero(t, n, "tombstones are not purged again")
+	})
+
+	t.Run("purge_keeps_tombstone", func(t *testing.T) {
+		opts := models.SetOptions{Alias: "promo", URLOptions: models.URLOptions{MaxClicks: 1}}
+		_, err := s.Set(ctx, fURL, "user", opts)
+		require.NoError(t, err)
+		_, err = s.Hit(ctx, "promo")
+		require.NoError(t, err)
+		require.NoError(t, s.SaveClicks(ctx, []models.ClickEvent{{ShortenID: "promo", Time: time.Now()}}))
+
+		_, err = s.PurgeExpired(ctx, time.Now())
+		require.NoError(t, err)
+
+		_, err = s.Hit(ctx, "promo")
+		assert.ErrorIs(t, err, ErrURLExpired)
+		var aliasErr AliasExistsError
+		_, err = s.Set(ctx, fURL, "other", models.SetOptions{Alias: "promo"})
+		assert.ErrorAs(t, err, &aliasErr, "alias of a consumed url must not be handed out again")
+
+		require.NoError(t, s.SaveClicks(ctx, []models.ClickEvent{{ShortenID: "promo", Time: time.Now()}}))
+		stats, err := s.GetStats(ctx, "promo")
+		require.NoError(t, err)
+		assert.Zero(t, stats.TotalClicks, "stats of the purged url are dropped")
+
+		entry, err := s.GetEntry(ctx, "promo")
+		require.NoError(t, err)
+		assert.True(t, entry.IsExpired)
+		assert.Empty(t, entry.FullURL)
+
+		_, err = s.UpdateURL(ctx, "user", "promo", func(target *models.Target) error {
+			target.FullURL = fURL
+			return nil
+		})
+		assert.ErrorIs(t, err, ErrURLExpired)
+	})
+
+	t.Run("redirect_code", func(t *testing.T) {
+		opts := models.SetOptions{URLOptions: models.URLOptions{RedirectCode: 308}}
+		sID, err := s.Set(ctx, fURL, "user", opts)
+		require.NoError(t, err, "url with own redirect code must not be deduplicated")
+
+		target, err := s.Hit(ctx, *sID)
+		require.NoError(t, err)
+		assert.Equal(t, &models.Target{FullURL: fURL, URLOptions: opts.URLOptions}, target)
+	})
+}
+
+func TestMemStorageStats(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage(service.RandomGenerator{Length: 8})
+
+	sID, err := s.Set(ctx, "https://ya.ru", "user", models.SetOptions{})
+	require.NoError(t, err)
+
+	day := time.Date(2024, 3, 1, 23, 30, 0, 0, time.UTC)
+	events := []models.ClickEvent{
+		{ShortenID: *sID, Time: day, Referrer: "t.me", IPHash: "a"},
+		{ShortenID: *sID, Time: day, IPHash: "a"},
+		{ShortenID: *sID, Time: day.Add(time.Hour), Referrer: "t.me", IPHash: "b"},
+		{ShortenID: "unknown", Time: day, IPHash: "c"},
+	}
+	require.NoError(t, s.SaveClicks(ctx, events))
+
+	stats, err := s.GetStats(ctx, *sID)
+	require.NoError(t, err)
+	assert.Equal(t, &models.URLStats{
+		TotalClicks:    3,
+		UniqueVisitors: 2,
+		ByDay:          map[string]int64{"2024-03-01": 2, "2024-03-02": 1},
+		ByReferrer:     map[string]int64{"t.me": 2, directReferrer: 1},
+	}, stats)
+
+	stats, err = s.GetStats(ctx, "unknown")
+	require.NoError(t, err)
+	assert.Nil(t, stats)
+}
+
+func TestMemStorageSetBatch(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage(service.RandomGenerator{Length: 8})
+
+	existing, err := s.Set(ctx, "https://ya.ru", "user", models.SetOptions{})
+	require.NoError(t, err)
+	_, err = s.Set(ctx, "https://google.ru", "user", models.SetOptions{Alias: "taken"})
+	require.NoError(t, err)
+
+	t.Run("results", func(t *testing.T) {
+		results, err := s.SetBatch(ctx, "user", []models.BatchItem{
+			{FullURL: "https://ya.ru"},
+			{FullURL: "https://practicum.yandex.ru"},
+			{FullURL: "https://practicum.yandex.ru"},
+			{FullURL: "https://ya.ru", SetOptions: models.SetOptions{Alias: "my-ya"}},
+		})
+		require.NoError(t, err)
+		require.Len(t, results, 4)
+
+		assert.Equal(t, models.BatchResult{ShortenID: *existing}, results[0])
+		assert.True(t, results[1].Created)
+		assert.Equal(t, models.BatchResult{ShortenID: results[1].ShortenID}, results[2])
+		assert.Equal(t, models.BatchResult{ShortenID: "my-ya", Created: true}, results[3])
+
+		fURL, err := s.Get(ctx, results[1].ShortenID)
+		require.NoError(t, err)
+		assert.Equal(t, models.FullURL("https://practicum.yandex.ru"), *fURL)
+	})
+
+	t.Run("alias_taken", func(t *testing.T) {
+		results, err := s.SetBatch(ctx, "other", []models.BatchItem{
+			{FullURL: "https://go.dev"},
+			{FullURL: "https://go.dev/doc", SetOptions: models.SetOptions{Alias: "taken"}},
+		})
+		require.NoError(t, err)
+		assert.True(t, results[0].Created)
+		assert.ErrorAs(t, results[1].Err, &AliasExistsError{})
+
+		urls, err := s.GetUserURLs(ctx, "other")
+		require.NoError(t, err)
+		assert.Equal(t, []models.UserURL{{ShortenID: results[0].ShortenID, FullURL: "https://go.dev"}}, urls)
+	})
+}
+
+func TestMemStorageUpdateURL(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage(service.RandomGenerator{Length: 8})
+
+	oldURL, newURL := models.FullURL("https://ya.ru/old"), models.FullURL("https://ya.ru/new")
+	sID, err := s.Set(ctx, oldURL, "user", models.SetOptions{})
+	require.NoError(t, err)
+
+	_, err = s.UpdateURL(ctx, "other", *sID, func(*models.Target) error { return nil })
+	assert.ErrorIs(t, err, ErrNotOwner)
+	_, err = s.UpdateURL(ctx, "user", "unknown", func(*models.Target) error { return nil })
+	assert.ErrorIs(t, err, ErrURLNotFound)
+
+	// ни ошибка update, ни изменение без изменений не попадают в историю
+	errInvalid := errors.New("invalid target")
+	_, err = s.UpdateURL(ctx, "user", *sID, func(target *models.Target) error {
+		target.FullURL = newURL
+		return errInvalid
+	})
+	assert.ErrorIs(t, err, errInvalid)
+	_, err = s.UpdateURL(ctx, "user", *sID, func(target *models.Target) error {
+		target.FullURL = oldURL
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrURLNotModified)
+
+	change, err := s.UpdateURL(ctx, "user", *sID, func(target *models.Target) error {
+		target.FullURL = newURL
+		target.RedirectCode = 301
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, oldURL, change.Old.FullURL)
+	assert.Equal(t, newURL, change.New.FullURL)
+
+	target, err := s.Lookup(ctx, *sID)
+	require.NoError(t, err)
+	assert.Equal(t, &models.Target{FullURL: newURL, URLOptions: models.URLOptions{RedirectCode: 301}}, target)
+
+	// отредактированный урл больше не выдается при сокращении ни прежнего, ни нового урла
+	for _, fURL := range []models.FullURL{oldURL, newURL} {
+		other, err := s.Set(ctx, fURL, "user", models.SetOptions{})
+		require.NoError(t, err, fURL)
+		assert.NotEqual(t, *sID, *other)
+	}
+
+	history, err := s.GetURLHistory(ctx, "user", *sID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.URLChange{*change}, history)
+	_, err = s.GetURLHistory(ctx, "other", *sID)
+	assert.ErrorIs(t, err, ErrNotOwner)
+
+	require.NoError(t, s.DeleteURLs(ctx, []models.DeleteTask{{UserID: "user", ShortenID: *sID}}))
+	_, err = s.UpdateURL(ctx, "user", *sID, func(*models.Target) error { return nil })
+	assert.ErrorIs(t, err, ErrURLDeleted)
+}
+
+func TestMemStorageGetEntry(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage(service.RandomGenerator{Length: 8})
+
+	before := time.Now()
+	sID, err := s.Set(ctx, "https://ya.ru", "user", models.SetOptions{URLOptions: models.URLOptions{MaxClicks: 1}})
+	require.NoError(t, err)
+	require.NoError(t, s.SaveClicks(ctx, []models.ClickEvent{{ShortenID: *sID, Time: time.Now()}}))
+
+	entry, err := s.GetEntry(ctx, *sID)
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.Equal(t, models.UserID("user"), entry.UserID)
+	assert.Equal(t, models.FullURL("https://ya.ru"), entry.FullURL)
+	assert.Equal(t, int64(1), entry.Clicks)
+	assert.False(t, entry.CreatedAt.Before(before))
+	assert.False(t, entry.IsExpired)
+
+	_, err = s.Hit(ctx, *sID)
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteURLs(ctx, []models.DeleteTask{{UserID: "user", ShortenID: *sID}}))
+
+	entry, err = s.GetEntry(ctx, *sID)
+	require.NoError(t, err)
+	require.NotNil(t, entry, "deleted and expired urls are still described")
+	assert.True(t, entry.IsExpired)
+	assert.True(t, entry.IsDeleted)
+
+	entry, err = s.GetEntry(ctx, "unknown")
+	require.NoError(t, err)
+	assert.Nil(t, entry)
+}
+
+func TestMemStorageGetByFullURL(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage(service.RandomGenerator{Length: 8})
+
+	sID, err := s.Set(ctx, "https://ya.ru", "user", models.SetOptions{})
+	require.NoError(t, err)
+	_, err = s.Set(ctx, "https://go.dev", "user", models.SetOptions{Alias: "golang"})
+	require.NoError(t, err)
+
+	found, err := s.GetByFullURL(ctx, "https://ya.ru")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, *sID, *found)
+
+	found, err = s.GetByFullURL(ctx, "https://go.dev")
+	require.NoError(t, err)
+	assert.Nil(t, found, "urls with alias are not deduplicated and not found")
+
+	require.NoError(t, s.DeleteURLs(ctx, []models.DeleteTask{{UserID: "user", ShortenID: *sID}}))
+	found, err = s.GetByFullURL(ctx, "https://ya.ru")
+	require.NoError(t, err)
+	assert.Nil(t, found)
+
+	reshortened, err := s.Set(ctx, "https://ya.ru", "user", models.SetOptions{})
+	require.NoError(t, err, "deleted url must leave the dedup index")
+	assert.NotEqual(t, *sID, *reshortened)
+	found, err = s.GetByFullURL(ctx, "https://ya.ru")
+	require.NoError(t, err)
+	assert.Equal(t, reshortened, found)
+}
@@ -0,0 +1,98 @@
+package storage
+
+import "github.com/nartim88/urlshortener/internal/pkg/models"
+
+const (
+	// statsDayLayout формат дня в статистике переходов
+	statsDayLayout = "2006-01-02"
+	// directReferrer источник прямых переходов, пришедших без Referer
+	directReferrer = "direct"
+)
+
+// clickStats агрегаты переходов по урлу для хранилищ, держащих данные в памяти
+type clickStats struct {
+	total      int64
+	visitors   map[string]struct{}
+	byDay      map[string]int64
+	byReferrer map[string]int64
+}
+
+func newClickStats() *clickStats {
+	return &clickStats{
+		visitors:   make(map[string]struct{}),
+		byDay:      make(map[string]int64),
+		byReferrer: make(map[string]int64),
+	}
+}
+
+// add учитывает переход в агрегатах
+func (c *clickStats) add(e models.ClickEvent) {
+	c.total++
+	if e.IPHash != "" {
+		c.visitors[e.IPHash] = struct{}{}
+	}
+	c.byDay[e.Time.UTC().Format(statsDayLayout)]++
+	c.byReferrer[referrerKeyOf(e.Referrer)]++
+}
+
+// merge добавляет к агрегатам прирост delta
+func (c *clickStats) merge(delta models.ClickStatsDelta) {
+	for day, n := range delta.ByDay {
+		c.byDay[day] += n
+		c.total += n
+	}
+	for ref, n := range delta.ByReferrer {
+		c.byReferrer[ref] += n
+	}
+	for _, ipHash := range delta.Visitors {
+		c.visitors[ipHash] = struct{}{}
+	}
+}
+
+// addToDelta учитывает переход в приросте delta. Посетитель попадает в прирост, только
+// если его еще нет ни в агрегатах c, ни в самом приросте, для чего служит seen
+func (c *clickStats) addToDelta(delta *models.ClickStatsDelta, seen map[string]struct{}, e models.ClickEvent) {
+	delta.ByDay[e.Time.UTC().Format(statsDayLayout)]++
+	delta.ByReferrer[referrerKeyOf(e.Referrer)]++
+	if e.IPHash == "" {
+		return
+	}
+	if _, ok := c.visitors[e.IPHash]; ok {
+		return
+	}
+	if _, ok := seen[e.IPHash]; ok {
+		return
+	}
+	seen[e.IPHash] = struct{}{}
+	delta.Visitors = append(delta.Visitors, e.IPHash)
+}
+
+// snapshot возвращает копию агрегатов, которую можно отдать за пределы блокировки
+func (c *clickStats) snapshot() *models.URLStats {
+	stats := models.URLStats{
+		TotalClicks:    c.total,
+		UniqueVisitors: int64(len(c.visitors)),
+		ByDay:          make(map[string]int64, len(c.byDay)),
+		ByReferrer:     make(map[string]int64, len(c.byReferrer)),
+	}
+	for k, v := range c.byDay {
+		stats.ByDay[k] = v
+	}
+	for k, v := range c.byReferrer {
+		stats.ByReferrer[k] = v
+	}
+	return &stats
+}
+
+// emptyStats статистика урла, по которому еще не было переходов
+func emptyStats() *models.URLStats {
+	return newClickStats().snapshot()
+}
+
+// referrerKeyOf ключ источника в статистике
+func referrerKeyOf(referrer string) string {
+	if referrer == "" {
+		return directReferrer
+	}
+	return referrer
+}
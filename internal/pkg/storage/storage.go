@@ -2,18 +2,82 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/nartim88/urlshortener/internal/pkg/models"
 )
 
-const shortURLLen = 8
+// maxIDAttempts число попыток сгенерировать свободный короткий идентификатор
+const maxIDAttempts = 10
 
 // Storage базовый интерфейс для работы с данными
 type Storage interface {
-	// Get возвращает полный урл по строковому идентификатору
+	// Get возвращает полный урл по строковому идентификатору.
+	// Для удаленного урла возвращает ErrURLDeleted, для истекшего — ErrURLExpired
 	Get(ctx context.Context, sID models.ShortenID) (*models.FullURL, error)
+	// Lookup работает как Get, но возвращает цель перехода вместе с параметрами урла
+	Lookup(ctx context.Context, sID models.ShortenID) (*models.Target, error)
+	// GetEntry возвращает запись урла со служебными данными или nil, если урла нет.
+	// В отличие от Lookup, удаленный и истекший урл возвращаются с признаками IsDeleted
+	// и IsExpired, а переход не засчитывается
+	GetEntry(ctx context.Context, sID models.ShortenID) (*models.URLEntry, error)
+	// Hit работает как Lookup и засчитывает переход по урлу. Проверка лимита и учет
+	// перехода атомарны, так что урл с MaxClicks открывается не больше MaxClicks раз
+	Hit(ctx context.Context, sID models.ShortenID) (*models.Target, error)
 	// Set сохраняет в базу полный УРЛ и соответствующий ему строковой идентификатор
-	Set(ctx context.Context, fURL models.FullURL) (*models.ShortenID, error)
+	// от имени пользователя uID. Если в opts задан Alias, он используется вместо
+	// сгенерированного идентификатора, а занятый Alias возвращает AliasExistsError.
+	// Урлы с параметрами, для которых URLOptions.Distinct, сохраняются без дедупликации
+	Set(ctx context.Context, fURL models.FullURL, uID models.UserID, opts models.SetOptions) (*models.ShortenID, error)
+	// SetBatch сохраняет пакет урлов от имени пользователя uID. Результаты идут в порядке
+	// items: урлы, сохраненные ранее или повторяющиеся в пакете, получают Created = false
+	// и уже выданный идентификатор, а урлы с занятым alias или без свободного идентификатора —
+	// Err и не сохраняются. Остальные урлы сохраняются атомарно: при ошибке не сохраняется ни один
+	SetBatch(ctx context.Context, uID models.UserID, items []models.BatchItem) ([]models.BatchResult, error)
+	// GetByFullURL возвращает идентификатор, под которым fURL сокращен без alias и параметров,
+	// или nil, если такого урла нет или он удален. Поиск идет по индексу дедупликации Set,
+	// так что урлы с alias, параметрами или измененной целью не находятся
+	GetByFullURL(ctx context.Context, fURL models.FullURL) (*models.ShortenID, error)
+	// GetUserURLs возвращает все урлы, сокращенные пользователем
+	GetUserURLs(ctx context.Context, uID models.UserID) ([]models.UserURL, error)
+	// DeleteURLs помечает урлы удаленными. Урл удаляется, только если задачу
+	// поставил его владелец
+	DeleteURLs(ctx context.Context, tasks []models.DeleteTask) error
+	// PurgeExpired оставляет от урлов, истекших к моменту now, надгробия и возвращает их
+	// количество. Цель, статистика и история урла удаляются, а идентификатор остается
+	// занят, и переход по нему по-прежнему дает ErrURLExpired
+	PurgeExpired(ctx context.Context, now time.Time) (int, error)
+	// SaveClicks добавляет переходы в статистику урлов. События по несуществующим
+	// урлам отбрасываются
+	SaveClicks(ctx context.Context, events []models.ClickEvent) error
+	// GetStats возвращает статистику переходов по урлу или nil, если урла нет
+	GetStats(ctx context.Context, sID models.ShortenID) (*models.URLStats, error)
+	// GetUTMDefaults возвращает UTM метки пользователя по умолчанию или nil, если они не заданы
+	GetUTMDefaults(ctx context.Context, uID models.UserID) (*models.UTM, error)
+	// SetUTMDefaults задает UTM метки пользователя по умолчанию, nil сбрасывает их
+	SetUTMDefaults(ctx context.Context, uID models.UserID, utm *models.UTM) error
+	// UpdateURL от имени пользователя uID меняет цель урла функцией update и добавляет
+	// изменение в историю урла. update вызывается с копией текущей цели под блокировкой
+	// урла и не должна обращаться к хранилищу. Ошибка update возвращается как есть, а если
+	// цель не изменилась, возвращается ErrURLNotModified: в обоих случаях урл и его история
+	// не меняются. Менять можно и истекший урл, но только свой, не удаленный и не очищенный
+	// PurgeExpired: иначе возвращается ErrURLNotFound, ErrNotOwner, ErrURLDeleted или ErrURLExpired.
+	// Измененный урл больше не участвует в дедупликации
+	UpdateURL(ctx context.Context, uID models.UserID, sID models.ShortenID, update func(*models.Target) error) (*models.URLChange, error)
+	// GetURLHistory возвращает изменения урла пользователя uID от старых к новым.
+	// Для несуществующего урла возвращает ErrURLNotFound, для чужого — ErrNotOwner
+	GetURLHistory(ctx context.Context, uID models.UserID, sID models.ShortenID) ([]models.URLChange, error)
+}
+
+// checkOwner проверяет, что пользователь uID может менять урл с владельцем owner
+func checkOwner(found bool, owner, uID models.UserID) error {
+	if !found {
+		return ErrURLNotFound
+	}
+	if owner != uID {
+		return ErrNotOwner
+	}
+	return nil
 }
 
 // StorageWithService расширенный интерфейс для работы с данными, подходящий для работы с
@@ -23,6 +87,8 @@ type StorageWithService interface {
 	// Bootstrap создание необходимых сущностей для начала работы с сервисом:
 	// таблиц и индексов в бд, файлов и пр.
 	Bootstrap(ctx context.Context) error
+	// Ping проверка доступности внешнего сервиса
+	Ping(ctx context.Context) error
 	// Close закрытие существующих соединений с внешними сервисами
 	Close(ctx context.Context) error
 }